@@ -0,0 +1,637 @@
+// Package client talks to a delta arm over its framed TCP protocol.
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/afking/godelta/delta"
+	"github.com/afking/godelta/workspace"
+	"github.com/golang/protobuf/proto"
+)
+
+// DefaultTimeout bounds a request when Client.Timeout is zero.
+const DefaultTimeout = 4 * time.Second
+
+// ArmError is an ERROR message, or a reply with a failed status, returned by
+// the arm.
+type ArmError struct {
+	Status delta.Message_Status
+	Info   string
+}
+
+func (e *ArmError) Error() string {
+	if e.Info == "" {
+		return "delta: arm error: " + e.Status.String()
+	}
+	return "delta: arm error: " + e.Status.String() + ": " + e.Info
+}
+
+// ErrClosed is returned by a Client after Close.
+var ErrClosed = errors.New("delta: client closed")
+
+// ErrDisconnected is returned while a dialled Client is reconnecting.
+var ErrDisconnected = errors.New("delta: disconnected")
+
+// errNoPong reports a link dropped after missed pings.
+var errNoPong = errors.New("delta: no reply to ping")
+
+// Client is a connection to a single delta arm. It is safe for concurrent
+// use. Replies are matched to requests by message ID, or for firmware that
+// does not echo IDs, to the oldest outstanding request of the same type.
+type Client struct {
+	// Workspace, if set, checks every outgoing POINT. Set it before use.
+	Workspace *workspace.Workspace
+
+	// Timeout bounds each request, zero means DefaultTimeout.
+	Timeout time.Duration
+
+	// Ack makes Start, Stop and SetMotor wait for an acknowledgement. Only
+	// firmware supporting message IDs replies to these.
+	Ack bool
+
+	dialer *Dialer
+	addr   string // redialled if dialer is set
+
+	ctx    context.Context // cancelled by Close
+	cancel context.CancelFunc
+
+	wmu sync.Mutex // serialises writes
+
+	mu      sync.Mutex
+	link    *link // nil while reconnecting
+	seq     uint32
+	calls   uint64 // requests made, ordering pending ones as IDs wrap
+	pending map[uint32]*request
+	started bool  // START sent since the last STOP
+	err     error // why the client stopped
+
+	recv chan *delta.Message // unsolicited messages
+	done chan struct{}       // closed when the client stops
+}
+
+// link is one connection to the arm.
+type link struct {
+	conn net.Conn
+	rd   *delta.Reader
+	wr   *delta.Writer
+	err  error         // why the read loop stopped
+	done chan struct{} // closed when the read loop stops
+}
+
+// request is an outstanding call waiting for its reply.
+type request struct {
+	typ delta.Message_Type
+	n   uint64 // order made
+	rsp chan *delta.Message
+}
+
+// Dial connects to the arm at addr, e.g. "192.168.1.10:80", reconnecting
+// with the default Dialer settings if the connection is lost.
+func Dial(ctx context.Context, addr string) (*Client, error) {
+	return (&Dialer{}).Dial(ctx, addr)
+}
+
+// New returns a Client using an established connection. It is not
+// reconnected when lost.
+func New(conn net.Conn) *Client {
+	c := newClient()
+	go c.run(c.connect(conn))
+	return c
+}
+
+func newClient() *Client {
+	c := &Client{
+		pending: make(map[uint32]*request),
+		recv:    make(chan *delta.Message, 64),
+		done:    make(chan struct{}),
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	return c
+}
+
+// connect starts reading conn and makes it the current link.
+func (c *Client) connect(conn net.Conn) *link {
+	l := &link{
+		conn: conn,
+		rd:   delta.NewReader(conn),
+		wr:   delta.NewWriter(conn),
+		done: make(chan struct{}),
+	}
+	go c.readLoop(l)
+	c.mu.Lock()
+	c.link = l
+	c.mu.Unlock()
+	return l
+}
+
+// Close closes the connection and stops reconnecting.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	l := c.link
+	c.mu.Unlock()
+	c.cancel()
+
+	var err error
+	if l != nil {
+		err = l.conn.Close()
+	}
+	<-c.done
+	return err
+}
+
+// RemoteAddr returns the address of the arm, or nil while reconnecting.
+func (c *Client) RemoteAddr() net.Addr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.link == nil {
+		return nil
+	}
+	return c.link.conn.RemoteAddr()
+}
+
+// Done is closed when the client stops, after Close or when a connection
+// that is not redialled is lost.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns why the client stopped, or nil if it has not.
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// current returns the link to write to.
+func (c *Client) current() (*link, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case c.err != nil:
+		return nil, c.err
+	case c.link == nil:
+		return nil, ErrDisconnected
+	}
+	return c.link, nil
+}
+
+// run supervises links until the client stops.
+func (c *Client) run(l *link) {
+	for {
+		err := c.watchLink(l)
+		c.mu.Lock()
+		c.link = nil
+		c.mu.Unlock()
+
+		if c.ctx.Err() != nil {
+			err = ErrClosed
+		}
+		if c.dialer == nil || err == ErrClosed {
+			c.stop(err)
+			return
+		}
+		c.notify(Disconnected, err)
+		if l = c.redial(); l == nil {
+			c.stop(ErrClosed)
+			return
+		}
+	}
+}
+
+// stop ends the client with err. Closed is notified before done is closed,
+// so OnState is not called after Close returns.
+func (c *Client) stop(err error) {
+	c.mu.Lock()
+	c.err = err
+	c.mu.Unlock()
+	c.notify(Closed, err)
+	close(c.done)
+}
+
+func (c *Client) notify(s State, err error) {
+	if c.dialer != nil && c.dialer.OnState != nil {
+		c.dialer.OnState(s, err)
+	}
+}
+
+// watchLink waits for l to drop, pinging it if the dialer asks for it, and
+// returns why it dropped.
+func (c *Client) watchLink(l *link) error {
+	var tick <-chan time.Time
+	if c.dialer != nil && c.dialer.PingInterval > 0 {
+		t := time.NewTicker(c.dialer.PingInterval)
+		defer t.Stop()
+		tick = t.C
+	}
+
+	missed := 0
+	for {
+		select {
+		case <-l.done:
+			return l.err
+		case <-c.ctx.Done():
+			l.conn.Close()
+			<-l.done
+			return ErrClosed
+		case <-tick:
+		}
+
+		// Pings do not read the exported options, which may be set
+		// after Dial.
+		ping := &delta.Message{Type: delta.Message_PING.Enum()}
+		_, err := c.call(c.ctx, ping, c.dialer.PingInterval)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			missed = 0
+			continue
+		}
+		if missed++; missed >= MaxMissed {
+			l.conn.Close()
+			<-l.done
+			return errNoPong
+		}
+	}
+}
+
+// redial reconnects with exponential backoff and resumes the session,
+// returning nil if the client was closed first.
+func (c *Client) redial() *link {
+	d := c.dialer.minBackoff()
+	for {
+		// Sleep between half and all of the backoff so arms dropped
+		// together do not redial in lockstep.
+		sleep := d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+		select {
+		case <-time.After(sleep):
+		case <-c.ctx.Done():
+			return nil
+		}
+
+		conn, err := c.dialer.dial(c.ctx, c.addr)
+		if err == nil {
+			l := c.connect(conn)
+			if err = c.resume(); err == nil {
+				c.notify(Connected, nil)
+				return l
+			}
+			c.mu.Lock()
+			c.link = nil
+			c.mu.Unlock()
+			conn.Close()
+			<-l.done
+		}
+		if c.ctx.Err() != nil {
+			return nil
+		}
+		c.notify(Disconnected, err)
+
+		if d *= 2; d > c.dialer.maxBackoff() {
+			d = c.dialer.maxBackoff()
+		}
+	}
+}
+
+// resume restores session state on a new link. START is sent without
+// waiting for an acknowledgement as it is safe to repeat.
+func (c *Client) resume() error {
+	c.mu.Lock()
+	started := c.started
+	c.mu.Unlock()
+	if !started {
+		return nil
+	}
+	return c.Send(c.ctx, &delta.Message{Type: delta.Message_START.Enum()})
+}
+
+func (c *Client) readLoop(l *link) {
+	defer close(l.done)
+	for {
+		msg := &delta.Message{}
+		if err := l.rd.Read(msg); err != nil {
+			var derr *delta.DecodeError
+			if errors.As(err, &derr) {
+				log.Println("client: ", err)
+				continue
+			}
+			l.err = err
+			return
+		}
+		c.trace(false, msg)
+
+		// An ERROR without an ID is matched to the oldest call, which it
+		// may not be about, so receivers see it too.
+		if c.dispatch(msg) && (msg.Id != nil || msg.GetType() != delta.Message_ERROR) {
+			continue
+		}
+		select {
+		case c.recv <- msg:
+		default: // nobody is receiving
+		}
+	}
+}
+
+// dispatch hands msg to the request it answers, reporting whether there was
+// one.
+func (c *Client) dispatch(msg *delta.Message) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	id := msg.GetId()
+	if msg.Id == nil {
+		var ok bool
+		if id, ok = c.oldest(msg.GetType()); !ok {
+			return false
+		}
+	}
+	r, ok := c.pending[id]
+	if !ok {
+		return false // late reply
+	}
+	delete(c.pending, id)
+	r.rsp <- msg
+	return true
+}
+
+// oldest returns the first outstanding request of type t, by the order the
+// requests were made since IDs wrap. ERRORs match a request of any type.
+func (c *Client) oldest(t delta.Message_Type) (uint32, bool) {
+	var id uint32
+	var first *request
+	for k, r := range c.pending {
+		if t != delta.Message_ERROR && r.typ != t {
+			continue
+		}
+		if first == nil || r.n < first.n {
+			id, first = k, r
+		}
+	}
+	return id, first != nil
+}
+
+// Send writes a single message. POINT messages are first checked against the
+// Workspace and may be rejected or clamped.
+func (c *Client) Send(ctx context.Context, msg *delta.Message) error {
+	l, err := c.current()
+	if err != nil {
+		return err
+	}
+	return c.send(ctx, l, msg)
+}
+
+func (c *Client) send(ctx context.Context, l *link, msg *delta.Message) error {
+	if msg.GetType() == delta.Message_POINT && c.Workspace != nil {
+		var err error
+		if msg, err = c.checkPoint(msg); err != nil {
+			return err
+		}
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	stop := watch(ctx, l.conn.SetWriteDeadline)
+	err := l.wr.Write(msg)
+	if err == nil {
+		c.trace(true, msg)
+	}
+	return stop(err)
+}
+
+func (c *Client) trace(sent bool, msg *delta.Message) {
+	if c.dialer != nil && c.dialer.Trace != nil {
+		c.dialer.Trace(sent, msg)
+	}
+}
+
+// checkPoint returns msg, or a copy with the point moved by the Workspace.
+func (c *Client) checkPoint(msg *delta.Message) (*delta.Message, error) {
+	p := msg.GetPoint()
+	if p == nil {
+		return msg, nil
+	}
+	x, y, z, err := c.Workspace.Check(p.GetX(), p.GetY(), p.GetZ())
+	if err != nil {
+		return nil, err
+	}
+	if x == p.GetX() && y == p.GetY() && z == p.GetZ() {
+		return msg, nil
+	}
+	msg = proto.Clone(msg).(*delta.Message)
+	msg.Point = &delta.Point{X: &x, Y: &y, Z: &z}
+	return msg, nil
+}
+
+// Recv returns the next message that was not a reply to a request, or was an
+// ERROR without an ID. Messages arriving while nobody is receiving are
+// buffered, then dropped.
+func (c *Client) Recv(ctx context.Context) (*delta.Message, error) {
+	select {
+	case msg := <-c.recv:
+		return msg, nil
+	default:
+	}
+	select {
+	case msg := <-c.recv:
+		return msg, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.done:
+		return nil, c.Err()
+	}
+}
+
+// Call sends msg with a new ID and waits for the reply. ERROR replies and
+// replies with a failed status are returned as *ArmError.
+func (c *Client) Call(ctx context.Context, msg *delta.Message) (*delta.Message, error) {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	return c.call(ctx, msg, timeout)
+}
+
+func (c *Client) call(ctx context.Context, msg *delta.Message, timeout time.Duration) (*delta.Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	r := &request{
+		typ: msg.GetType(),
+		rsp: make(chan *delta.Message, 1),
+	}
+	l, err := c.current()
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.seq++
+	if c.seq == 0 {
+		c.seq++
+	}
+	id := c.seq
+	c.calls++
+	r.n = c.calls
+	c.pending[id] = r
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	msg = proto.Clone(msg).(*delta.Message)
+	msg.Id = &id
+	if err := c.send(ctx, l, msg); err != nil {
+		return nil, err
+	}
+
+	select {
+	case rsp := <-r.rsp:
+		if rsp.GetType() == delta.Message_ERROR {
+			return nil, &ArmError{Status: delta.Message_INVALID, Info: rsp.GetInfo()}
+		}
+		if rsp.GetType() != msg.GetType() {
+			return nil, fmt.Errorf("delta: got %s reply to %s", rsp.GetType(), msg.GetType())
+		}
+		if rsp.GetStatus() != delta.Message_OK {
+			return nil, &ArmError{Status: rsp.GetStatus(), Info: rsp.GetInfo()}
+		}
+		return rsp, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("delta: %s %d: %w", msg.GetType(), id, ctx.Err())
+	case <-l.done:
+		return nil, l.err
+	}
+}
+
+// command sends a message without a reply, or calls it if c.Ack is set.
+func (c *Client) command(ctx context.Context, msg *delta.Message) error {
+	if !c.Ack {
+		return c.Send(ctx, msg)
+	}
+	_, err := c.Call(ctx, msg)
+	return err
+}
+
+// Ping sends a PING and returns the round trip time.
+func (c *Client) Ping(ctx context.Context) (time.Duration, error) {
+	t := time.Now()
+	if _, err := c.Call(ctx, &delta.Message{Type: delta.Message_PING.Enum()}); err != nil {
+		return 0, err
+	}
+	return time.Since(t), nil
+}
+
+// Start allows motor positioning commands. A dialled Client sends START
+// again after reconnecting, until Stop.
+func (c *Client) Start(ctx context.Context) error {
+	if err := c.command(ctx, &delta.Message{Type: delta.Message_START.Enum()}); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.started = true
+	c.mu.Unlock()
+	return nil
+}
+
+// Stop makes the arm ignore motor positioning commands.
+func (c *Client) Stop(ctx context.Context) error {
+	c.mu.Lock()
+	c.started = false
+	c.mu.Unlock()
+	return c.command(ctx, &delta.Message{Type: delta.Message_STOP.Enum()})
+}
+
+// EStop sends STOP straight away, without waiting for an acknowledgement
+// and regardless of any cancelled context, and stops START being resent on
+// reconnecting.
+func (c *Client) EStop() error {
+	c.mu.Lock()
+	c.started = false
+	c.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+	return c.Send(ctx, &delta.Message{Type: delta.Message_STOP.Enum()})
+}
+
+// Heartbeat tells the arm to stop itself unless another heartbeat follows
+// within watchdog. A zero watchdog disarms it.
+func (c *Client) Heartbeat(ctx context.Context, watchdog time.Duration) error {
+	ms := uint32(watchdog / time.Millisecond)
+	return c.Send(ctx, &delta.Message{
+		Type:     delta.Message_HEARTBEAT.Enum(),
+		Watchdog: &ms,
+	})
+}
+
+// MoveTo sends a POINT command in metres.
+func (c *Client) MoveTo(ctx context.Context, x, y, z float64) error {
+	return c.Send(ctx, &delta.Message{
+		Type: delta.Message_POINT.Enum(),
+		Point: &delta.Point{
+			X: &x,
+			Y: &y,
+			Z: &z,
+		},
+	})
+}
+
+// GetMotor requests the state of motor id.
+func (c *Client) GetMotor(ctx context.Context, id int32) (*delta.Motor, error) {
+	rsp, err := c.Call(ctx, &delta.Message{
+		Type:  delta.Message_GET.Enum(),
+		Motor: &delta.Motor{Id: &id},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if rsp.Motor == nil {
+		return nil, fmt.Errorf("delta: GET reply for motor %d has no motor", id)
+	}
+	return rsp.Motor, nil
+}
+
+// SetMotor sends motor configuration. Only the fields set on m are changed.
+func (c *Client) SetMotor(ctx context.Context, m *delta.Motor) error {
+	return c.command(ctx, &delta.Message{
+		Type:  delta.Message_SET.Enum(),
+		Motor: m,
+	})
+}
+
+// watch applies the deadline and cancellation of ctx to a connection
+// deadline setter for the duration of one operation. The returned function
+// ends the watch and translates errors caused by ctx into ctx.Err().
+func watch(ctx context.Context, set func(time.Time) error) func(error) error {
+	d, _ := ctx.Deadline()
+	set(d)
+	if ctx.Done() == nil {
+		return func(err error) error { return err }
+	}
+
+	done := make(chan struct{})
+	exit := make(chan struct{})
+	go func() {
+		defer close(exit)
+		select {
+		case <-ctx.Done():
+			set(time.Unix(1, 0)) // unblock pending io
+		case <-done:
+		}
+	}()
+
+	return func(err error) error {
+		close(done)
+		<-exit
+		if err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+}
@@ -0,0 +1,551 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/afking/godelta/delta"
+	"github.com/afking/godelta/sim"
+	"github.com/golang/protobuf/proto"
+)
+
+// serve starts a simulated arm, returning it and its address.
+func serve(t *testing.T) (*sim.Server, string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := sim.New()
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	return s, l.Addr().String()
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestClientSim(t *testing.T) {
+	s, addr := serve(t)
+	ctx := testContext(t)
+
+	c, err := Dial(ctx, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Ack = true
+
+	if _, err := c.Ping(ctx); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+	if err := c.Start(ctx); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if !s.Started() {
+		t.Error("arm not started after acknowledged Start")
+	}
+
+	m, err := c.GetMotor(ctx, 2)
+	if err != nil {
+		t.Fatalf("GetMotor: %v", err)
+	}
+	if m.GetId() != 2 || m.GetP() != 32 {
+		t.Errorf("GetMotor(2) = %v", m)
+	}
+	if err := c.SetMotor(ctx, &delta.Motor{Id: m.Id, P: new(int32)}); err != nil {
+		t.Fatalf("SetMotor: %v", err)
+	}
+	if p := s.Motor(2).GetP(); p != 0 {
+		t.Errorf("motor 2 P = %d after SetMotor, want 0", p)
+	}
+
+	// Unacknowledged POINTs are answered by nothing, so ping after them
+	// to know they have arrived.
+	if err := c.MoveTo(ctx, 0.01, 0.02, -0.03); err != nil {
+		t.Fatalf("MoveTo: %v", err)
+	}
+	if _, err := c.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if x, y, z := s.Point(); x != 0.01 || y != 0.02 || z != -0.03 {
+		t.Errorf("arm at (%v, %v, %v), want (0.01, 0.02, -0.03)", x, y, z)
+	}
+
+	if err := c.Stop(ctx); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	if s.Started() {
+		t.Error("arm started after acknowledged Stop")
+	}
+}
+
+func TestClientArmError(t *testing.T) {
+	_, addr := serve(t)
+	ctx := testContext(t)
+
+	c, err := Dial(ctx, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	_, err = c.GetMotor(ctx, 9)
+	aerr, ok := err.(*ArmError)
+	if !ok {
+		t.Fatalf("GetMotor(9) = %v, want *ArmError", err)
+	}
+	if aerr.Status != delta.Message_INVALID {
+		t.Errorf("GetMotor(9) status %v, want INVALID", aerr.Status)
+	}
+}
+
+func TestClientClose(t *testing.T) {
+	_, addr := serve(t)
+	ctx := testContext(t)
+
+	c, err := Dial(ctx, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	select {
+	case <-c.Done():
+	default:
+		t.Error("Done not closed after Close")
+	}
+	if _, err := c.Ping(ctx); err != ErrClosed {
+		t.Errorf("Ping after Close = %v, want ErrClosed", err)
+	}
+	if _, err := c.Recv(ctx); err != ErrClosed {
+		t.Errorf("Recv after Close = %v, want ErrClosed", err)
+	}
+}
+
+// fakeArm is the arm end of a pipe to a Client, answering as a test directs.
+type fakeArm struct {
+	t  *testing.T
+	rd *delta.Reader
+	wr *delta.Writer
+}
+
+func pipe(t *testing.T) (*Client, *fakeArm) {
+	cc, ac := net.Pipe()
+	c := New(cc)
+	t.Cleanup(func() {
+		ac.Close()
+		c.Close()
+	})
+	return c, &fakeArm{t: t, rd: delta.NewReader(ac), wr: delta.NewWriter(ac)}
+}
+
+func (a *fakeArm) read() *delta.Message {
+	a.t.Helper()
+	msg := &delta.Message{}
+	if err := a.rd.Read(msg); err != nil {
+		a.t.Fatalf("arm: read: %v", err)
+	}
+	return msg
+}
+
+func (a *fakeArm) write(msg *delta.Message) {
+	a.t.Helper()
+	if err := a.wr.Write(msg); err != nil {
+		a.t.Fatalf("arm: write: %v", err)
+	}
+}
+
+type result struct {
+	rsp *delta.Message
+	err error
+}
+
+// call runs c.Call in the background.
+func call(ctx context.Context, c *Client, msg *delta.Message) <-chan result {
+	r := make(chan result, 1)
+	go func() {
+		rsp, err := c.Call(ctx, msg)
+		r <- result{rsp, err}
+	}()
+	return r
+}
+
+func reply(req *delta.Message, id bool) *delta.Message {
+	rsp := &delta.Message{Type: req.Type, Status: delta.Message_OK.Enum(), Info: req.Info}
+	if id {
+		rsp.Id = req.Id
+	}
+	return rsp
+}
+
+func ping(info string) *delta.Message {
+	return &delta.Message{Type: delta.Message_PING.Enum(), Info: proto.String(info)}
+}
+
+func TestCallOutOfOrder(t *testing.T) {
+	c, arm := pipe(t)
+	ctx := testContext(t)
+
+	ra := call(ctx, c, ping("a"))
+	a := arm.read()
+	rb := call(ctx, c, ping("b"))
+	b := arm.read()
+	if a.Id == nil || b.Id == nil || a.GetId() == b.GetId() {
+		t.Fatalf("calls sent with ids %v and %v, want distinct ids", a.Id, b.Id)
+	}
+
+	arm.write(reply(b, true))
+	arm.write(reply(a, true))
+	for _, tt := range []struct {
+		r    <-chan result
+		want string
+	}{
+		{ra, "a"},
+		{rb, "b"},
+	} {
+		r := <-tt.r
+		if r.err != nil || r.rsp.GetInfo() != tt.want {
+			t.Errorf("call %s = %v, %v", tt.want, r.rsp, r.err)
+		}
+	}
+}
+
+func TestCallError(t *testing.T) {
+	c, arm := pipe(t)
+	ctx := testContext(t)
+
+	ra := call(ctx, c, ping("a"))
+	a := arm.read()
+	rb := call(ctx, c, &delta.Message{Type: delta.Message_GET.Enum(), Motor: &delta.Motor{Id: proto.Int32(9)}})
+	b := arm.read()
+
+	// An ERROR with an ID answers that call, not the oldest
+	arm.write(&delta.Message{Type: delta.Message_ERROR.Enum(), Id: b.Id, Info: proto.String("unknown motor 9")})
+	r := <-rb
+	aerr, ok := r.err.(*ArmError)
+	if !ok || aerr.Info != "unknown motor 9" {
+		t.Errorf("GET = %v, %v, want *ArmError", r.rsp, r.err)
+	}
+
+	arm.write(reply(a, true))
+	if r := <-ra; r.err != nil || r.rsp.GetInfo() != "a" {
+		t.Errorf("PING = %v, %v", r.rsp, r.err)
+	}
+}
+
+func TestCallFailedStatus(t *testing.T) {
+	c, arm := pipe(t)
+	ctx := testContext(t)
+
+	r := call(ctx, c, &delta.Message{Type: delta.Message_START.Enum()})
+	rsp := reply(arm.read(), true)
+	rsp.Status = delta.Message_IGNORED.Enum()
+	arm.write(rsp)
+	res := <-r
+	if aerr, ok := res.err.(*ArmError); !ok || aerr.Status != delta.Message_IGNORED {
+		t.Errorf("START = %v, %v, want IGNORED *ArmError", res.rsp, res.err)
+	}
+}
+
+func TestCallWithoutIDs(t *testing.T) {
+	c, arm := pipe(t)
+	ctx := testContext(t)
+
+	// Firmware not echoing IDs: replies go to the oldest call of their
+	// type, ERRORs to the oldest call of any type.
+	ra := call(ctx, c, ping("a"))
+	a := arm.read()
+	rg := call(ctx, c, &delta.Message{Type: delta.Message_GET.Enum(), Motor: &delta.Motor{Id: proto.Int32(1)}})
+	g := arm.read()
+	rb := call(ctx, c, ping("b"))
+	arm.read()
+
+	arm.write(&delta.Message{Type: delta.Message_GET.Enum(), Motor: g.Motor})
+	if r := <-rg; r.err != nil || r.rsp.GetMotor().GetId() != 1 {
+		t.Errorf("GET = %v, %v", r.rsp, r.err)
+	}
+	arm.write(reply(a, false))
+	if r := <-ra; r.err != nil || r.rsp.GetInfo() != "a" {
+		t.Errorf("PING a = %v, %v", r.rsp, r.err)
+	}
+	arm.write(&delta.Message{Type: delta.Message_ERROR.Enum(), Info: proto.String("busy")})
+	if r := <-rb; r.err == nil {
+		t.Errorf("PING b = %v, want ERROR", r.rsp)
+	}
+}
+
+func TestCallWithoutIDsWrap(t *testing.T) {
+	c, arm := pipe(t)
+	ctx := testContext(t)
+	c.mu.Lock()
+	c.seq = math.MaxUint32 - 2
+	c.mu.Unlock()
+
+	// IDs wrap past zero between the calls; the oldest is still the first
+	// made
+	ra := call(ctx, c, ping("a"))
+	a := arm.read()
+	rb := call(ctx, c, ping("b"))
+	b := arm.read()
+	rc := call(ctx, c, ping("c"))
+	cc := arm.read()
+	if a.GetId() != math.MaxUint32-1 || b.GetId() != math.MaxUint32 || cc.GetId() != 1 {
+		t.Fatalf("calls sent with ids %d, %d and %d, want a wrap to 1", a.GetId(), b.GetId(), cc.GetId())
+	}
+
+	arm.write(&delta.Message{Type: delta.Message_ERROR.Enum(), Info: proto.String("busy")})
+	if r := <-ra; r.err == nil {
+		t.Errorf("PING a = %v, want ERROR", r.rsp)
+	}
+	arm.write(reply(b, false))
+	if r := <-rb; r.err != nil || r.rsp.GetInfo() != "b" {
+		t.Errorf("PING b = %v, %v", r.rsp, r.err)
+	}
+	arm.write(reply(cc, false))
+	if r := <-rc; r.err != nil || r.rsp.GetInfo() != "c" {
+		t.Errorf("PING c = %v, %v", r.rsp, r.err)
+	}
+}
+
+func TestLateReply(t *testing.T) {
+	c, arm := pipe(t)
+	ctx := testContext(t)
+
+	short, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	r := call(short, c, ping("late"))
+	late := arm.read()
+	if res := <-r; !errors.Is(res.err, context.DeadlineExceeded) {
+		t.Fatalf("PING = %v, %v, want deadline exceeded", res.rsp, res.err)
+	}
+
+	// The late reply is not a reply to the next call, nor lost to Recv
+	r = call(ctx, c, ping("next"))
+	next := arm.read()
+	arm.write(reply(late, true))
+	arm.write(reply(next, true))
+	if res := <-r; res.err != nil || res.rsp.GetInfo() != "next" {
+		t.Errorf("PING = %v, %v, want next", res.rsp, res.err)
+	}
+	msg, err := c.Recv(ctx)
+	if err != nil || msg.GetInfo() != "late" {
+		t.Errorf("Recv = %v, %v, want the late reply", msg, err)
+	}
+}
+
+// dropListener keeps the connections it accepts so a test can drop them.
+type dropListener struct {
+	net.Listener
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func (l *dropListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.mu.Lock()
+		l.conns = append(l.conns, conn)
+		l.mu.Unlock()
+	}
+	return conn, err
+}
+
+// drop closes every connection accepted so far.
+func (l *dropListener) drop() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, conn := range l.conns {
+		conn.Close()
+	}
+	l.conns = nil
+}
+
+// stateLog records the states a Dialer reports.
+type stateLog struct {
+	mu     sync.Mutex
+	states []State
+}
+
+func (sl *stateLog) add(s State, err error) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	sl.states = append(sl.states, s)
+}
+
+func (sl *stateLog) get() []State {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	return append([]State(nil), sl.states...)
+}
+
+// count returns how many times s was reported.
+func (sl *stateLog) count(s State) int {
+	n := 0
+	for _, v := range sl.get() {
+		if v == s {
+			n++
+		}
+	}
+	return n
+}
+
+// waitFor polls cond until it holds or a few seconds have passed.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for end := time.Now().Add(5 * time.Second); !cond(); time.Sleep(time.Millisecond) {
+		if time.Now().After(end) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dl := &dropListener{Listener: l}
+	s := sim.New()
+	go s.Serve(dl)
+	defer s.Close()
+	ctx := testContext(t)
+
+	var log stateLog
+	d := &Dialer{
+		MinBackoff: 10 * time.Millisecond,
+		MaxBackoff: 50 * time.Millisecond,
+		OnState: func(st State, err error) {
+			if st == Closed {
+				time.Sleep(10 * time.Millisecond) // Close must wait for it
+			}
+			log.add(st, err)
+		},
+	}
+	c, err := d.Dial(ctx, l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Ack = true
+	if err := c.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The arm resets and the link drops: START is resent on reconnecting
+	s.Handle(&delta.Message{Type: delta.Message_STOP.Enum()})
+	dl.drop()
+	waitFor(t, "reconnect", func() bool { return log.count(Connected) == 1 })
+	if _, err := c.Ping(ctx); err != nil {
+		t.Fatalf("Ping after reconnecting: %v", err)
+	}
+	if !s.Started() {
+		t.Error("START not resent after reconnecting")
+	}
+	if log.count(Disconnected) == 0 {
+		t.Errorf("states %v, want a disconnect before reconnecting", log.get())
+	}
+
+	// After Stop it is not
+	if err := c.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	dl.drop()
+	waitFor(t, "second reconnect", func() bool { return log.count(Connected) == 2 })
+	if _, err := c.Ping(ctx); err != nil {
+		t.Fatalf("Ping after reconnecting: %v", err)
+	}
+	if s.Started() {
+		t.Error("START resent after Stop")
+	}
+
+	if err := c.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	states := log.get()
+	if len(states) == 0 || states[len(states)-1] != Closed {
+		t.Errorf("states after Close %v, want Closed last", states)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if n := len(log.get()); n != len(states) {
+		t.Errorf("states %v reported after Close returned", log.get()[len(states):])
+	}
+}
+
+func TestReconnectBackoff(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dl := &dropListener{Listener: l}
+	s := sim.New()
+	go s.Serve(dl)
+	ctx := testContext(t)
+
+	var log stateLog
+	d := &Dialer{MinBackoff: 5 * time.Millisecond, MaxBackoff: 10 * time.Millisecond, OnState: log.add}
+	c, err := d.Dial(ctx, l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// With the arm gone redials keep failing until Close
+	s.Close()
+	waitFor(t, "failed redials", func() bool { return log.count(Disconnected) >= 3 })
+	if _, err := c.Ping(ctx); err != ErrDisconnected {
+		t.Errorf("Ping while disconnected = %v, want ErrDisconnected", err)
+	}
+	if c.RemoteAddr() != nil {
+		t.Errorf("RemoteAddr while disconnected = %v, want nil", c.RemoteAddr())
+	}
+	c.Close()
+	if err := c.Err(); err != ErrClosed {
+		t.Errorf("Err after Close = %v, want ErrClosed", err)
+	}
+	if states := log.get(); states[len(states)-1] != Closed {
+		t.Errorf("states after Close %v, want Closed last", states)
+	}
+}
+
+func TestRecvError(t *testing.T) {
+	c, arm := pipe(t)
+	ctx := testContext(t)
+
+	// Unsolicited messages go to Recv
+	arm.write(&delta.Message{Type: delta.Message_ERROR.Enum(), Info: proto.String("overheating")})
+	if msg, err := c.Recv(ctx); err != nil || msg.GetInfo() != "overheating" {
+		t.Errorf("Recv = %v, %v, want the ERROR", msg, err)
+	}
+
+	// An ERROR without an ID fails the oldest call and is received too
+	r := call(ctx, c, ping("keepalive"))
+	arm.read()
+	arm.write(&delta.Message{Type: delta.Message_ERROR.Enum(), Info: proto.String("motor 2 stalled")})
+	if res := <-r; res.err == nil {
+		t.Errorf("PING = %v, want ERROR", res.rsp)
+	}
+	if msg, err := c.Recv(ctx); err != nil || msg.GetInfo() != "motor 2 stalled" {
+		t.Errorf("Recv = %v, %v, want the ERROR", msg, err)
+	}
+
+	// An ERROR with an ID is only the call's
+	r = call(ctx, c, ping("a"))
+	a := arm.read()
+	arm.write(&delta.Message{Type: delta.Message_ERROR.Enum(), Id: a.Id, Info: proto.String("bad ping")})
+	if res := <-r; res.err == nil {
+		t.Errorf("PING = %v, want ERROR", res.rsp)
+	}
+	short, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if msg, err := c.Recv(short); err == nil {
+		t.Errorf("Recv = %v, want nothing", msg)
+	}
+}
@@ -0,0 +1,120 @@
+package client
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/afking/godelta/delta"
+)
+
+// KeepAlive is the TCP keep-alive period set on dialled connections.
+const KeepAlive = 4 * time.Second
+
+// Redial backoff defaults.
+const (
+	DefaultMinBackoff = 100 * time.Millisecond
+	DefaultMaxBackoff = 10 * time.Second
+)
+
+// MaxMissed is the number of consecutive unanswered pings after which a
+// connection is considered dead.
+const MaxMissed = 3
+
+// State of a dialled Client's connection.
+type State int
+
+const (
+	Connected    State = iota // reconnected
+	Disconnected              // lost, or a redial failed
+	Closed                    // stopped for good
+)
+
+func (s State) String() string {
+	switch s {
+	case Connected:
+		return "connected"
+	case Disconnected:
+		return "disconnected"
+	case Closed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// Dialer holds options for connecting to an arm. Clients it dials reconnect
+// when the connection is lost, with exponential backoff and jitter, and send
+// START again if the arm was started.
+type Dialer struct {
+	// MinBackoff and MaxBackoff bound the wait between redials, zero means
+	// DefaultMinBackoff and DefaultMaxBackoff.
+	MinBackoff, MaxBackoff time.Duration
+
+	// PingInterval, if set, pings the arm while connected and drops the
+	// connection after MaxMissed pings time out.
+	PingInterval time.Duration
+
+	// OnState, if set, is called from the client's own goroutine when the
+	// connection is lost, after each failed redial, on reconnecting, and when
+	// the client stops, never after Close returns. err is the cause, nil
+	// for Connected.
+	OnState func(s State, err error)
+
+	// Trace, if set, is called with every message written, as sent, and
+	// every message read. It must not block.
+	Trace func(sent bool, msg *delta.Message)
+}
+
+// Dial connects to the arm at addr. ctx bounds the first connection only.
+func (d *Dialer) Dial(ctx context.Context, addr string) (*Client, error) {
+	conn, err := d.dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	dc := *d
+	c := newClient()
+	c.dialer = &dc
+	c.addr = addr
+	go c.run(c.connect(conn))
+	return c, nil
+}
+
+// dial opens a connection, bounding redials by DefaultTimeout.
+func (d *Dialer) dial(ctx context.Context, addr string) (net.Conn, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultTimeout)
+		defer cancel()
+	}
+
+	var nd net.Dialer
+	conn, err := nd.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		if err := tcp.SetKeepAlive(true); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if err := tcp.SetKeepAlivePeriod(KeepAlive); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (d *Dialer) minBackoff() time.Duration {
+	if d.MinBackoff > 0 {
+		return d.MinBackoff
+	}
+	return DefaultMinBackoff
+}
+
+func (d *Dialer) maxBackoff() time.Duration {
+	if d.MaxBackoff > 0 {
+		return d.MaxBackoff
+	}
+	return DefaultMaxBackoff
+}
@@ -0,0 +1,481 @@
+// Package config loads client settings from a file, the environment and
+// flags.
+//
+// Settings are applied in order of precedence: defaults, then the file, then
+// DELTA_* environment variables, then flags. Every setting has a dotted key,
+// e.g. "workspace.mode", whose environment variable is the key upper cased
+// with dots and dashes replaced by underscores, e.g. DELTA_WORKSPACE_MODE.
+//
+// Files are a subset of TOML: tables, key = value pairs, strings, numbers,
+// booleans and # comments. Durations are strings such as "4s".
+package config
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/afking/godelta/kinematics"
+)
+
+// Config is the client configuration.
+type Config struct {
+	Addr         string        // arm address, host:port
+	Timeout      time.Duration // connection and request timeout
+	PingInterval time.Duration // keepalive pings, 0 to disable
+	Ack          bool          // wait for command acknowledgements
+	Heartbeat    time.Duration // heartbeat period while moving, 0 to disable
+	Watchdog     time.Duration // arm stops if heartbeats cease this long
+
+	Sim    string // simulator listen address
+	Listen string // golisten listen address
+	Proxy  string // matlab proxy UDP address
+
+	Workspace  Workspace
+	Geometry   kinematics.Geometry
+	Controller Controller
+	Buttons    Buttons
+
+	// Arms are named profiles, tables such as [arm.left]. Only arms in the
+	// file are read from the environment.
+	Arms map[string]*Arm `config:"arm"`
+}
+
+// Arm is a named arm. Zero and unset settings inherit the top level ones.
+type Arm struct {
+	Addr         string
+	Timeout      time.Duration
+	PingInterval time.Duration
+	Ack          *bool
+}
+
+// Workspace selects the POINT bounds.
+type Workspace struct {
+	Bounds string // reach, cylinder, sphere or none
+	Mode   string // reject, clamp or warn
+
+	Radius  float64 // cylinder and sphere
+	ZMin    float64 // cylinder
+	ZMax    float64
+	CenterX float64 // sphere
+	CenterY float64
+	CenterZ float64
+}
+
+// Controller maps a gamepad to the arm.
+type Controller struct {
+	Input  string // usb for a wired Xbox 360 pad, or evdev
+	Device string // evdev pad: path, vendor:product or name, "" for any
+	Rate   int    // setpoints per second
+
+	// In absolute mode the sticks give the position, in velocity mode they
+	// jog it, up to Limit from the origin.
+	Mode  string
+	Scale float64 // metres, or metres per second, at full deflection
+	Limit float64
+
+	Deadzone     float64 // each stick's combined deflection ignored, 0 to 1
+	AxisDeadzone float64 // each axis's deflection ignored, 0 to 1
+	Expo         float64 // response curve, 0 linear to 1 cubic
+
+	// Axes driving x, y and z: left-x, left-y, right-x, right-y,
+	// left-trigger or right-trigger.
+	X, Y, Z string
+
+	// Per axis multipliers of Scale, negative to invert.
+	ScaleX, ScaleY, ScaleZ float64
+}
+
+// Buttons binds gamepad buttons, e.g. "a", "lb" or "thumb-l", to actions. An
+// empty binding disables the action.
+type Buttons struct {
+	Start  string // send START
+	Stop   string // send STOP
+	Home   string // return to the origin
+	EStop  string // stop until the command is restarted
+	Save   string // save a waypoint
+	Recall string // go to the next waypoint
+	Slower string // step the speed down
+	Faster string // step the speed up
+}
+
+// Default returns the built in configuration.
+func Default() *Config {
+	return &Config{
+		Addr:         "192.168.1.10:80",
+		Timeout:      4 * time.Second,
+		PingInterval: time.Second,
+		Heartbeat:    100 * time.Millisecond,
+		Watchdog:     500 * time.Millisecond,
+
+		Sim:    "127.0.0.1:2616",
+		Listen: "192.168.1.100:2616",
+		Proxy:  ":8080",
+
+		Workspace: Workspace{
+			Bounds: "reach",
+			Mode:   "reject",
+			Radius: 0.05,
+			ZMin:   -0.05,
+			ZMax:   0.05,
+		},
+		Geometry: kinematics.Default,
+		Controller: Controller{
+			Input:    "usb",
+			Rate:     333,
+			Mode:     "absolute",
+			Scale:    0.04,
+			Limit:    0.05,
+			Deadzone: 0.15,
+			X:        "left-x",
+			Y:        "left-y",
+			Z:        "right-y",
+			ScaleX:   1,
+			ScaleY:   1,
+			ScaleZ:   1,
+		},
+		Buttons: Buttons{
+			Start:  "start",
+			Stop:   "back",
+			Home:   "a",
+			EStop:  "b",
+			Save:   "x",
+			Recall: "y",
+			Slower: "lb",
+			Faster: "rb",
+		},
+		Arms: map[string]*Arm{},
+	}
+}
+
+// ArmNames returns the names of the arm profiles in order.
+func (c *Config) ArmNames() []string {
+	var names []string
+	for name := range c.Arms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Arm returns the configuration for the named arm, or c itself for "".
+func (c *Config) Arm(name string) (*Config, error) {
+	if name == "" {
+		return c, nil
+	}
+	a, ok := c.Arms[name]
+	if !ok {
+		return nil, fmt.Errorf("config: unknown arm %q", name)
+	}
+	ac := *c
+	if a.Addr != "" {
+		ac.Addr = a.Addr
+	}
+	if a.Timeout != 0 {
+		ac.Timeout = a.Timeout
+	}
+	if a.PingInterval != 0 {
+		ac.PingInterval = a.PingInterval
+	}
+	if a.Ack != nil {
+		ac.Ack = *a.Ack
+	}
+	return &ac, nil
+}
+
+// Check reports settings that cannot work together.
+func (c *Config) Check() error {
+	if c.Timeout <= 0 {
+		return fmt.Errorf("config: timeout must be positive")
+	}
+	if c.PingInterval < 0 || c.Heartbeat < 0 {
+		return fmt.Errorf("config: ping-interval and heartbeat must not be negative")
+	}
+	if c.Heartbeat > 0 && c.Watchdog <= c.Heartbeat {
+		return fmt.Errorf("config: watchdog %v must be longer than heartbeat %v", c.Watchdog, c.Heartbeat)
+	}
+	for _, name := range c.ArmNames() {
+		if a := c.Arms[name]; a.Timeout < 0 || a.PingInterval < 0 {
+			return fmt.Errorf("config: arm %s: timeout and ping-interval must not be negative", name)
+		}
+	}
+	return nil
+}
+
+// DefaultPath returns the file Load reads when no path is given, which need
+// not exist.
+func DefaultPath() string {
+	if p := os.Getenv("DELTA_CONFIG"); p != "" {
+		return p
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return dir + "/delta/config.toml"
+}
+
+// Load returns the defaults overridden by the file at path and then the
+// environment. An empty path reads DefaultPath if it exists.
+func Load(path string) (*Config, error) {
+	c := Default()
+	if path == "" {
+		path = DefaultPath()
+		if _, err := os.Stat(path); err != nil {
+			path = ""
+		}
+	}
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if err := c.Decode(f); err != nil {
+			if e, ok := err.(*Error); ok {
+				e.File = path
+			}
+			return nil, err
+		}
+	}
+	if err := c.Env(os.LookupEnv); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Env applies DELTA_* variables found by lookup.
+func (c *Config) Env(lookup func(string) (string, bool)) error {
+	for _, f := range fields(c) {
+		v, ok := lookup(EnvName(f.key))
+		if !ok {
+			continue
+		}
+		if err := f.set(v); err != nil {
+			return fmt.Errorf("config: %s: %v", EnvName(f.key), err)
+		}
+	}
+	return nil
+}
+
+// EnvName returns the environment variable for key.
+func EnvName(key string) string {
+	r := strings.NewReplacer(".", "_", "-", "_")
+	return "DELTA_" + strings.ToUpper(r.Replace(key))
+}
+
+// Set parses value into the setting key, e.g. from a flag. Setting a key of
+// an unknown arm adds it.
+func (c *Config) Set(key, value string) error {
+	f, ok := c.field(key)
+	if !ok {
+		return fmt.Errorf("config: unknown setting %q", key)
+	}
+	if err := f.set(value); err != nil {
+		return fmt.Errorf("config: %s: %v", key, err)
+	}
+	return nil
+}
+
+// field returns the setting key, adding an arm for keys like arm.name.addr.
+func (c *Config) field(key string) (field, bool) {
+	for _, f := range fields(c) {
+		if f.key == key {
+			return f, true
+		}
+	}
+	s := strings.Split(key, ".")
+	if len(s) != 3 || s[0] != "arm" || s[1] == "" {
+		return field{}, false
+	}
+	for _, f := range armFields(s[1], &Arm{}) {
+		if f.key == key {
+			if c.Arms == nil {
+				c.Arms = map[string]*Arm{}
+			}
+			c.Arms[s[1]] = f.arm
+			return f, true
+		}
+	}
+	return field{}, false
+}
+
+// Keys returns every setting key in file order.
+func (c *Config) Keys() []string {
+	var ks []string
+	for _, f := range fields(c) {
+		ks = append(ks, f.key)
+	}
+	return ks
+}
+
+// Encode writes c as a file Load can read.
+func (c *Config) Encode(w io.Writer) error {
+	table := ""
+	for _, f := range fields(c) {
+		t, k := "", f.key
+		if i := strings.LastIndexByte(f.key, '.'); i >= 0 {
+			t, k = f.key[:i], f.key[i+1:]
+		}
+		if t != table {
+			if _, err := fmt.Fprintf(w, "\n[%s]\n", t); err != nil {
+				return err
+			}
+			table = t
+		}
+		if f.v.Kind() == reflect.Ptr && f.v.IsNil() {
+			continue // unset
+		}
+		if _, err := fmt.Fprintf(w, "%s = %s\n", k, f.format()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// field is a setting and the value it is stored in.
+type field struct {
+	key string
+	v   reflect.Value
+	arm *Arm // holding v, for arm settings
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// fields lists the settings of c, top level keys first and arms last.
+func fields(c *Config) []field {
+	var top, tables []field
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		name := keyName(v.Type().Field(i))
+		fv := v.Field(i)
+		switch fv.Kind() {
+		case reflect.Map:
+			continue
+		case reflect.Struct:
+			for j := 0; j < fv.NumField(); j++ {
+				tables = append(tables, field{key: name + "." + keyName(fv.Type().Field(j)), v: fv.Field(j)})
+			}
+		default:
+			top = append(top, field{key: name, v: fv})
+		}
+	}
+	for _, name := range c.ArmNames() {
+		tables = append(tables, armFields(name, c.Arms[name])...)
+	}
+	return append(top, tables...)
+}
+
+// armFields lists the settings of the arm called name.
+func armFields(name string, a *Arm) []field {
+	var fs []field
+	v := reflect.ValueOf(a).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		fs = append(fs, field{
+			key: "arm." + name + "." + keyName(v.Type().Field(i)),
+			v:   v.Field(i),
+			arm: a,
+		})
+	}
+	return fs
+}
+
+// keyName returns the config tag of f, or its name in lower case with dashes
+// between words.
+func keyName(f reflect.StructField) string {
+	if t := f.Tag.Get("config"); t != "" {
+		return t
+	}
+	var b strings.Builder
+	for i, r := range f.Name {
+		if r >= 'A' && r <= 'Z' {
+			if i > 0 {
+				b.WriteByte('-')
+			}
+			r += 'a' - 'A'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// typ returns the type of the setting, the element type for optional
+// settings.
+func (f field) typ() reflect.Type {
+	if t := f.v.Type(); t.Kind() == reflect.Ptr {
+		return t.Elem()
+	}
+	return f.v.Type()
+}
+
+// quoted reports whether the field is a quoted string in files.
+func (f field) quoted() bool {
+	t := f.typ()
+	return t.Kind() == reflect.String || t == durationType
+}
+
+// set parses s into the field.
+func (f field) set(s string) error {
+	if f.v.Kind() != reflect.Ptr {
+		return setValue(f.v, s)
+	}
+	p := reflect.New(f.typ())
+	if err := setValue(p.Elem(), s); err != nil {
+		return err
+	}
+	f.v.Set(p)
+	return nil
+}
+
+func setValue(v reflect.Value, s string) error {
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(s)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Int:
+		n, err := strconv.ParseInt(s, 10, 0)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case v.Kind() == reflect.Float64:
+		x, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(x)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// format returns the field as a file value.
+func (f field) format() string {
+	v := reflect.Indirect(f.v)
+	switch {
+	case v.Type() == durationType:
+		return strconv.Quote(time.Duration(v.Int()).String())
+	case v.Kind() == reflect.String:
+		return strconv.Quote(v.String())
+	case v.Kind() == reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64)
+	}
+	return fmt.Sprint(v.Interface())
+}
@@ -0,0 +1,271 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecode(t *testing.T) {
+	c := Default()
+	err := c.Decode(strings.NewReader(`# top level
+addr = "10.0.0.2:80"  # trailing comment
+timeout = "2s"
+ack = true
+sim = 'localhost:#1' # a # in a string is not a comment
+
+[workspace]
+mode = "clamp"
+radius = 0.1
+z-min = -0.02
+
+[ controller ]
+rate = 1_000
+expo = 1
+x = "right-x"
+
+[arm.left]
+addr = "10.0.0.3:80"
+ack = false
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Default()
+	want.Addr = "10.0.0.2:80"
+	want.Timeout = 2 * time.Second
+	want.Ack = true
+	want.Sim = "localhost:#1"
+	want.Workspace.Mode = "clamp"
+	want.Workspace.Radius = 0.1
+	want.Workspace.ZMin = -0.02
+	want.Controller.Rate = 1000
+	want.Controller.Expo = 1
+	want.Controller.X = "right-x"
+	no := false
+	want.Arms["left"] = &Arm{Addr: "10.0.0.3:80", Ack: &no}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("Decode =\n%+v\nwant\n%+v", c, want)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	for _, tt := range []struct {
+		file string
+		line int
+		msg  string
+	}{
+		{"addr", 1, "want key = value"},
+		{"\n[workspace", 2, "unterminated table header"},
+		{"[]", 1, "empty table name"},
+		{"adr = \"x\"", 1, `unknown setting "adr"`},
+		{"[workspace]\naddr = \"x\"", 2, `unknown setting "workspace.addr"`},
+		{"addr =", 1, "addr: missing value"},
+		{"addr = \"x", 1, `addr: bad string "x`},
+		{"addr = 'x", 1, "addr: bad string 'x"},
+		{"addr = 80", 1, "addr: want string"},
+		{"timeout = 4", 1, "timeout: want time.Duration"},
+		{"ack = \"true\"", 1, "ack: want bool"},
+		{"timeout = \"4\"", 1, `timeout: time: missing unit in duration "4"`},
+		{"ack = yes", 1, `ack: strconv.ParseBool: parsing "yes": invalid syntax`},
+		{"[controller]\nrate = 1.5", 2, `controller.rate: strconv.ParseInt: parsing "1.5": invalid syntax`},
+		{"[arm.left]\nack = 2", 2, `arm.left.ack: strconv.ParseBool: parsing "2": invalid syntax`},
+	} {
+		err := Default().Decode(strings.NewReader(tt.file))
+		var cerr *Error
+		if !errors.As(err, &cerr) || cerr.Line != tt.line || cerr.Msg != tt.msg {
+			t.Errorf("%q: %v, want line %d: %s", tt.file, err, tt.line, tt.msg)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	c := Default()
+	yes := true
+	c.Arms["left"] = &Arm{Addr: "10.0.0.3:80", Ack: &yes}
+	c.Arms["right"] = &Arm{Timeout: time.Second}
+	c.Workspace.ZMin = -0.125
+
+	var b bytes.Buffer
+	if err := c.Encode(&b); err != nil {
+		t.Fatal(err)
+	}
+	// An unset ack is left out
+	if strings.Count(b.String(), "ack = ") != 2 {
+		t.Errorf("Encode wrote ack for an arm without one:\n%s", &b)
+	}
+	got := Default()
+	if err := got.Decode(&b); err != nil {
+		t.Fatalf("Decode(Encode()): %v", err)
+	}
+	if !reflect.DeepEqual(got, c) {
+		t.Errorf("Decode(Encode()) =\n%+v\nwant\n%+v", got, c)
+	}
+}
+
+func TestEnv(t *testing.T) {
+	env := map[string]string{
+		"DELTA_ADDR":               "10.0.0.9:80",
+		"DELTA_PING_INTERVAL":      "0s",
+		"DELTA_WORKSPACE_BOUNDS":   "none",
+		"DELTA_CONTROLLER_Z_MIN":   "ignored, not a setting",
+		"DELTA_ARM_LEFT_ADDR":      "10.0.0.4:80",
+		"DELTA_ARM_RIGHT_ADDR":     "not in the file, so ignored",
+		"DELTA_CONTROLLER_SCALE_X": "-1",
+	}
+	c := Default()
+	c.Arms["left"] = &Arm{Addr: "10.0.0.3:80"}
+	if err := c.Env(func(k string) (string, bool) { v, ok := env[k]; return v, ok }); err != nil {
+		t.Fatal(err)
+	}
+	if c.Addr != "10.0.0.9:80" || c.PingInterval != 0 || c.Workspace.Bounds != "none" || c.Controller.ScaleX != -1 {
+		t.Errorf("Env = %+v", c)
+	}
+	if len(c.Arms) != 1 || c.Arms["left"].Addr != "10.0.0.4:80" {
+		t.Errorf("Env arms = %v", c.Arms)
+	}
+
+	err := Default().Env(func(k string) (string, bool) { return "soon", k == "DELTA_TIMEOUT" })
+	if err == nil || !strings.Contains(err.Error(), "DELTA_TIMEOUT") {
+		t.Errorf("Env with a bad timeout = %v, want an error naming DELTA_TIMEOUT", err)
+	}
+
+	for key, want := range map[string]string{
+		"addr":                   "DELTA_ADDR",
+		"workspace.z-min":        "DELTA_WORKSPACE_Z_MIN",
+		"arm.left.ping-interval": "DELTA_ARM_LEFT_PING_INTERVAL",
+	} {
+		if got := EnvName(key); got != want {
+			t.Errorf("EnvName(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	err := os.WriteFile(path, []byte(`addr = "file:80"
+timeout = "1s"
+heartbeat = "50ms"
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DELTA_TIMEOUT", "2s")
+	t.Setenv("DELTA_HEARTBEAT", "60ms")
+
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Flags are applied with Set after Load
+	if err := c.Set("heartbeat", "70ms"); err != nil {
+		t.Fatal(err)
+	}
+	if c.Addr != "file:80" || c.Timeout != 2*time.Second || c.Heartbeat != 70*time.Millisecond {
+		t.Errorf("addr %q, timeout %v, heartbeat %v, want from the file, env and flag", c.Addr, c.Timeout, c.Heartbeat)
+	}
+	if c.Watchdog != Default().Watchdog {
+		t.Errorf("watchdog %v, want the default", c.Watchdog)
+	}
+
+	// Errors name the file
+	if err := os.WriteFile(path, []byte("\nbogus = 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil || err.Error() != "config: "+path+`:2: unknown setting "bogus"` {
+		t.Errorf("Load = %v, want an error naming the file and line", err)
+	}
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.toml")); err == nil {
+		t.Error("Load of a missing file succeeded")
+	}
+}
+
+func TestSet(t *testing.T) {
+	c := Default()
+	if err := c.Set("arm.new.addr", "10.0.0.5:80"); err != nil {
+		t.Fatal(err)
+	}
+	if a := c.Arms["new"]; a == nil || a.Addr != "10.0.0.5:80" {
+		t.Errorf("Set added arm %+v", a)
+	}
+	for _, kv := range [][2]string{
+		{"nonsense", "1"},
+		{"arm.new", "1"},
+		{"arm..addr", "x"},
+		{"controller.rate", "fast"},
+	} {
+		if err := c.Set(kv[0], kv[1]); err == nil {
+			t.Errorf("Set(%q, %q) succeeded", kv[0], kv[1])
+		}
+	}
+}
+
+func TestArm(t *testing.T) {
+	yes, no := true, false
+	c := Default()
+	c.Ack = true
+	c.Arms["quiet"] = &Arm{Ack: &no}
+	c.Arms["loud"] = &Arm{Ack: &yes, Timeout: time.Second}
+	c.Arms["plain"] = &Arm{Addr: "10.0.0.3:80"}
+
+	for _, tt := range []struct {
+		name    string
+		addr    string
+		timeout time.Duration
+		ack     bool
+	}{
+		{"", c.Addr, c.Timeout, true},
+		{"quiet", c.Addr, c.Timeout, false}, // a profile can turn ack off
+		{"loud", c.Addr, time.Second, true},
+		{"plain", "10.0.0.3:80", c.Timeout, true},
+	} {
+		ac, err := c.Arm(tt.name)
+		if err != nil {
+			t.Fatalf("Arm(%q): %v", tt.name, err)
+		}
+		if ac.Addr != tt.addr || ac.Timeout != tt.timeout || ac.Ack != tt.ack {
+			t.Errorf("Arm(%q) = addr %q, timeout %v, ack %v, want %q, %v, %v", tt.name, ac.Addr, ac.Timeout, ac.Ack, tt.addr, tt.timeout, tt.ack)
+		}
+	}
+	if _, err := c.Arm("missing"); err == nil {
+		t.Error("Arm of an unknown name succeeded")
+	}
+	if got := c.ArmNames(); !reflect.DeepEqual(got, []string{"loud", "plain", "quiet"}) {
+		t.Errorf("ArmNames = %v", got)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	if err := Default().Check(); err != nil {
+		t.Errorf("Check of the defaults: %v", err)
+	}
+	for _, tt := range []struct {
+		name string
+		set  func(*Config)
+		ok   bool
+	}{
+		{"zero timeout", func(c *Config) { c.Timeout = 0 }, false},
+		{"negative timeout", func(c *Config) { c.Timeout = -time.Second }, false},
+		{"negative ping", func(c *Config) { c.PingInterval = -time.Second }, false},
+		{"no pings", func(c *Config) { c.PingInterval = 0 }, true},
+		{"no watchdog", func(c *Config) { c.Watchdog = 0 }, false},
+		{"watchdog as heartbeat", func(c *Config) { c.Watchdog = c.Heartbeat }, false},
+		{"watchdog under heartbeat", func(c *Config) { c.Watchdog = c.Heartbeat / 2 }, false},
+		{"no heartbeat", func(c *Config) { c.Heartbeat, c.Watchdog = 0, 0 }, true},
+		{"negative heartbeat", func(c *Config) { c.Heartbeat = -time.Second }, false},
+		{"negative arm timeout", func(c *Config) { c.Arms["a"] = &Arm{Timeout: -1} }, false},
+		{"inherited arm timeout", func(c *Config) { c.Arms["a"] = &Arm{} }, true},
+	} {
+		c := Default()
+		tt.set(c)
+		if err := c.Check(); (err == nil) != tt.ok {
+			t.Errorf("%s: Check = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
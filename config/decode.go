@@ -0,0 +1,114 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Error reports the line a file failed on.
+type Error struct {
+	File string
+	Line int
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	if e.File == "" {
+		return fmt.Sprintf("config: line %d: %s", e.Line, e.Msg)
+	}
+	return fmt.Sprintf("config: %s:%d: %s", e.File, e.Line, e.Msg)
+}
+
+// Decode applies the settings in a file. Unknown keys are an error so typos
+// are not silently ignored.
+func (c *Config) Decode(r io.Reader) error {
+	table := ""
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		errorf := func(format string, a ...interface{}) error {
+			return &Error{Line: n, Msg: fmt.Sprintf(format, a...)}
+		}
+
+		line := strings.TrimSpace(stripComment(sc.Text()))
+		if line == "" {
+			continue
+		}
+		if line[0] == '[' {
+			if line[len(line)-1] != ']' {
+				return errorf("unterminated table header")
+			}
+			table = strings.TrimSpace(line[1 : len(line)-1])
+			if table == "" {
+				return errorf("empty table name")
+			}
+			continue
+		}
+
+		i := strings.IndexByte(line, '=')
+		if i < 0 {
+			return errorf("want key = value")
+		}
+		key := strings.TrimSpace(line[:i])
+		if table != "" {
+			key = table + "." + key
+		}
+		f, ok := c.field(key)
+		if !ok {
+			return errorf("unknown setting %q", key)
+		}
+
+		v, quoted, err := literal(strings.TrimSpace(line[i+1:]))
+		if err != nil {
+			return errorf("%s: %v", key, err)
+		}
+		if quoted != f.quoted() {
+			return errorf("%s: want %s", key, f.typ())
+		}
+		if err := f.set(v); err != nil {
+			return errorf("%s: %v", key, err)
+		}
+	}
+	return sc.Err()
+}
+
+// stripComment removes a # comment outside of strings.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote == 0 && c == '#':
+			return line[:i]
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == '"' && c == '\\':
+			i++
+		case c == quote:
+			quote = 0
+		}
+	}
+	return line
+}
+
+// literal parses a value, reporting whether it was a string.
+func literal(s string) (string, bool, error) {
+	if s == "" {
+		return "", false, fmt.Errorf("missing value")
+	}
+	switch s[0] {
+	case '"':
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return "", false, fmt.Errorf("bad string %s", s)
+		}
+		return v, true, nil
+	case '\'':
+		if len(s) < 2 || s[len(s)-1] != '\'' || strings.IndexByte(s[1:len(s)-1], '\'') >= 0 {
+			return "", false, fmt.Errorf("bad string %s", s)
+		}
+		return s[1 : len(s)-1], true, nil
+	}
+	return strings.Replace(s, "_", "", -1), false, nil
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/afking/godelta/delta"
+	"github.com/afking/godelta/pcap"
+	"github.com/afking/godelta/record"
+	"github.com/codegangsta/cli"
+)
+
+// decode prints the messages in a session file, a pcap capture or a raw
+// stream of frames, read from a file or stdin. Frames that fail to decode,
+// unknown fields and missing required fields are flagged with "!".
+func decode(c *cli.Context) {
+	in := io.Reader(os.Stdin)
+	if name := c.Args().First(); name != "" && name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	d := &decoder{w: bufio.NewWriter(os.Stdout), port: c.Int("port")}
+	err := d.decode(in)
+	d.w.Flush()
+	if err != nil {
+		log.Fatal("decode: ", err)
+	}
+	if d.flagged > 0 {
+		log.Fatalf("decode: %d of %d frames flagged", d.flagged, d.frames)
+	}
+}
+
+// decoder prints frames and counts the flagged ones
+type decoder struct {
+	w    *bufio.Writer
+	port int // pcap streams to or from this port, 0 for all
+
+	frames, flagged int
+}
+
+// decode detects the input format from its first bytes
+func (d *decoder) decode(r io.Reader) error {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(len(record.Magic))
+	switch {
+	case string(head) == record.Magic:
+		return d.session(br)
+	case pcap.IsCapture(head):
+		return d.capture(br)
+	}
+	return d.stream(br, func(off int) string { return fmt.Sprintf("@%d", off) })
+}
+
+// session prints a record session with time offsets and directions
+func (d *decoder) session(r io.Reader) error {
+	sr, err := record.NewReader(r)
+	if err != nil {
+		return err
+	}
+	for {
+		f, err := sr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		prefix := fmt.Sprintf("%10.6f %s", f.Time.Seconds(), f.Dir)
+		if !d.frame(prefix, f.Msg, err) {
+			return nil
+		}
+	}
+}
+
+// capture prints each TCP stream in a pcap capture, timed from the first
+// packet
+func (d *decoder) capture(r io.Reader) error {
+	streams, err := pcap.Streams(r)
+	if err != nil && len(streams) == 0 {
+		return err
+	}
+	if err != nil {
+		log.Println("decode: ", err)
+	}
+
+	var start time.Time
+	for _, s := range streams {
+		if t := s.TimeAt(0); start.IsZero() || t.Before(start) {
+			start = t
+		}
+	}
+	n := 0
+	for _, s := range streams {
+		if d.port != 0 && !hasPort(s.Src, d.port) && !hasPort(s.Dst, d.port) {
+			continue
+		}
+		n++
+		fmt.Fprintf(d.w, "# %s > %s, %d bytes", s.Src, s.Dst, len(s.Data))
+		if s.Gaps > 0 {
+			fmt.Fprintf(d.w, ", %d gaps", s.Gaps)
+		}
+		fmt.Fprintln(d.w)
+		s := s
+		if err := d.stream(bytes.NewReader(s.Data), func(off int) string {
+			return fmt.Sprintf("%10.6f", s.TimeAt(off).Sub(start).Seconds())
+		}); err != nil {
+			return err
+		}
+	}
+	if n == 0 {
+		return fmt.Errorf("no TCP streams with data")
+	}
+	return nil
+}
+
+func hasPort(addr string, port int) bool {
+	return strings.HasSuffix(addr, fmt.Sprintf(":%d", port))
+}
+
+// stream prints raw frames, labelled by prefix with the offset each starts at
+func (d *decoder) stream(r io.Reader, prefix func(off int) string) error {
+	// delta.Reader reuses a bufio.Reader that is large enough, so the frame
+	// offset is what was read less what is still buffered.
+	cr := &countReader{r: r}
+	br := bufio.NewReaderSize(cr, 4096)
+	fr := delta.NewReaderSize(br, delta.MaxFrameSize)
+	for {
+		off := cr.n - br.Buffered()
+		msg := &delta.Message{}
+		err := fr.Read(msg)
+		if err == io.EOF {
+			return nil
+		}
+		if !d.frame(prefix(off), msg, err) {
+			return nil
+		}
+	}
+}
+
+type countReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+// frame prints one message and its problems, returning false if the stream
+// cannot continue
+func (d *decoder) frame(prefix string, msg *delta.Message, err error) bool {
+	d.frames++
+	var decodeErr *delta.DecodeError
+	if err != nil && !errors.As(err, &decodeErr) {
+		// Out of sync, nothing more can be read
+		fmt.Fprintf(d.w, "%s ! %v\n", prefix, err)
+		d.flagged++
+		return false
+	}
+
+	fmt.Fprintf(d.w, "%s %s\n", prefix, describe(msg))
+	var problems []string
+	if err != nil {
+		problems = append(problems, "malformed message: "+decodeErr.Err.Error())
+	}
+	problems = append(problems, checkMessage(msg)...)
+	for _, p := range problems {
+		fmt.Fprintf(d.w, "%*s ! %s\n", len(prefix), "", p)
+	}
+	if len(problems) > 0 {
+		d.flagged++
+	}
+	return true
+}
+
+// describe formats msg on one line
+func describe(msg *delta.Message) string {
+	var s []string
+	if msg.Type == nil {
+		s = append(s, "<no type>")
+	} else if _, ok := delta.Message_Type_name[int32(msg.GetType())]; ok {
+		s = append(s, msg.GetType().String())
+	} else {
+		s = append(s, fmt.Sprintf("type(%d)", msg.GetType()))
+	}
+	if msg.Id != nil {
+		s = append(s, fmt.Sprintf("id=%d", msg.GetId()))
+	}
+	if msg.Status != nil {
+		s = append(s, "status="+msg.GetStatus().String())
+	}
+	if p := msg.Point; p != nil {
+		s = append(s, fmt.Sprintf("point=(%s, %s, %s)", float(p.X), float(p.Y), float(p.Z)))
+	}
+	if m := msg.Motor; m != nil {
+		ms := []string{"id=" + integer(m.Id)}
+		for _, f := range motorFields {
+			if v := *f.field(m); v != nil {
+				ms = append(ms, f.name+"="+integer(v))
+			}
+		}
+		s = append(s, "motor{"+strings.Join(ms, " ")+"}")
+	}
+	if msg.Watchdog != nil {
+		s = append(s, fmt.Sprintf("watchdog=%dms", msg.GetWatchdog()))
+	}
+	if msg.Info != nil {
+		s = append(s, fmt.Sprintf("info=%q", msg.GetInfo()))
+	}
+	return strings.Join(s, " ")
+}
+
+func float(v *float64) string {
+	if v == nil {
+		return "?"
+	}
+	return fmt.Sprintf("%.6g", *v)
+}
+
+func integer(v *int32) string {
+	if v == nil {
+		return "?"
+	}
+	return fmt.Sprint(*v)
+}
+
+// checkMessage lists missing required fields, unknown enum values and
+// unknown fields in msg
+func checkMessage(msg *delta.Message) []string {
+	var p []string
+	if msg.Type == nil {
+		p = append(p, "missing required Message.type")
+	} else if _, ok := delta.Message_Type_name[int32(msg.GetType())]; !ok {
+		p = append(p, fmt.Sprintf("unknown Message.type %d", msg.GetType()))
+	}
+	if msg.Status != nil {
+		if _, ok := delta.Message_Status_name[int32(msg.GetStatus())]; !ok {
+			p = append(p, fmt.Sprintf("unknown Message.status %d", msg.GetStatus()))
+		}
+	}
+	p = append(p, unknownFields("Message", msg.XXX_unrecognized)...)
+
+	if pt := msg.Point; pt != nil {
+		for _, f := range []struct {
+			name string
+			v    *float64
+		}{{"x", pt.X}, {"y", pt.Y}, {"z", pt.Z}} {
+			if f.v == nil {
+				p = append(p, "missing required Point."+f.name)
+			}
+		}
+		p = append(p, unknownFields("Point", pt.XXX_unrecognized)...)
+	}
+	if m := msg.Motor; m != nil {
+		if m.Id == nil {
+			p = append(p, "missing required Motor.id")
+		}
+		p = append(p, unknownFields("Motor", m.XXX_unrecognized)...)
+	}
+	return p
+}
+
+// unknownFields describes the fields in unrecognized wire data
+func unknownFields(name string, b []byte) []string {
+	var p []string
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return append(p, fmt.Sprintf("malformed unknown %s field", name))
+		}
+		b = b[n:]
+		num, wire := tag>>3, tag&7
+		p = append(p, fmt.Sprintf("unknown field %s.%d (wire type %d)", name, num, wire))
+
+		switch wire {
+		case 0:
+			_, n = binary.Uvarint(b)
+		case 1:
+			n = 8
+		case 2:
+			var l uint64
+			l, n = binary.Uvarint(b)
+			if n > 0 {
+				n += int(l)
+			}
+		case 5:
+			n = 4
+		default:
+			return p // groups are not used by this protocol
+		}
+		if n <= 0 || n > len(b) {
+			return p
+		}
+		b = b[n:]
+	}
+	return p
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/afking/godelta/delta"
+	"github.com/golang/protobuf/proto"
+)
+
+// frames returns msgs written as a stream of frames.
+func frames(t *testing.T, msgs ...*delta.Message) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	w := delta.NewWriter(&b)
+	for _, m := range msgs {
+		if err := w.Write(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return b.Bytes()
+}
+
+// rawFrame returns data with its length prefix.
+func rawFrame(data ...byte) []byte {
+	return append(binary.AppendUvarint(nil, uint64(len(data))), data...)
+}
+
+// decodeAll decodes in, returning the output and the frame counts.
+func decodeAll(t *testing.T, in []byte) (out string, frames, flagged int, err error) {
+	t.Helper()
+	var b bytes.Buffer
+	d := &decoder{w: bufio.NewWriter(&b)}
+	err = d.decode(bytes.NewReader(in))
+	d.w.Flush()
+	return b.String(), d.frames, d.flagged, err
+}
+
+func TestDecodeFlags(t *testing.T) {
+	ping := &delta.Message{Type: delta.Message_PING.Enum(), Info: proto.String("hi")}
+	unknown := frames(t, ping)
+	unknown = rawFrame(append(unknown[1:], 15<<3|0, 1)...) // field 15, varint 1
+
+	for _, tt := range []struct {
+		name    string
+		in      []byte
+		frames  int
+		flagged int
+		want    []string
+	}{
+		{"valid", frames(t, ping,
+			&delta.Message{Type: delta.Message_POINT.Enum(), Id: proto.Uint32(3), Point: &delta.Point{X: proto.Float64(0.01), Y: proto.Float64(0), Z: proto.Float64(-0.02)}},
+		), 2, 0, []string{`@0 PING info="hi"`, "POINT id=3 point=(0.01, 0, -0.02)"}},
+		{"malformed", append(rawFrame(0x0a, 0xff), frames(t, ping)...), 2, 1, []string{"! malformed message", `@3 PING info="hi"`}},
+		{"missing type", rawFrame(0x12, 0x01, 'x'), 1, 1, []string{"! missing required Message.type"}},
+		{"unknown enum", rawFrame(0x08, 0x63), 1, 1, []string{"type(99)", "! unknown Message.type 99"}},
+		{"unknown field", unknown, 1, 1, []string{"! unknown field Message.15 (wire type 0)"}},
+		{"missing point field", frames(t, &delta.Message{Type: delta.Message_POINT.Enum(), Point: &delta.Point{X: proto.Float64(1), Y: proto.Float64(2)}}),
+			1, 1, []string{"point=(1, 2, ?)", "! missing required Point.z"}},
+		{"truncated", append(frames(t, ping), 0x05, 0x08), 2, 1, []string{"PING", "! delta: frame truncated after 1 of 5 bytes"}},
+	} {
+		out, frames, flagged, err := decodeAll(t, tt.in)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if frames != tt.frames || flagged != tt.flagged {
+			t.Errorf("%s: %d frames, %d flagged, want %d, %d\n%s", tt.name, frames, flagged, tt.frames, tt.flagged, out)
+		}
+		for _, w := range tt.want {
+			if !strings.Contains(out, w) {
+				t.Errorf("%s: output missing %q:\n%s", tt.name, w, out)
+			}
+		}
+	}
+}
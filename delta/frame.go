@@ -0,0 +1,146 @@
+package delta
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// Frames on the TCP link are a base 128 varint length prefix followed by the
+// marshalled Message. This is the layout written by nanopb's
+// pb_encode_delimited and read by pb_decode_delimited on the arm.
+
+// MaxFrameSize is the default limit on the message size of a frame, sized to
+// the receive buffer on the arm.
+const MaxFrameSize = 512
+
+// FrameSizeError reports a frame whose length exceeds the configured maximum.
+type FrameSizeError struct {
+	Size uint64
+	Max  int
+}
+
+func (e *FrameSizeError) Error() string {
+	return fmt.Sprintf("delta: frame size %d exceeds max %d", e.Size, e.Max)
+}
+
+// TruncatedFrameError reports a frame that ended before its declared length.
+type TruncatedFrameError struct {
+	Size int // declared message size, or -1 if the prefix was cut short
+	Read int // message bytes read before the stream ended
+}
+
+func (e *TruncatedFrameError) Error() string {
+	if e.Size < 0 {
+		return "delta: frame truncated in length prefix"
+	}
+	return fmt.Sprintf("delta: frame truncated after %d of %d bytes", e.Read, e.Size)
+}
+
+// DecodeError reports a complete frame whose message failed to unmarshal.
+// The stream is still in sync and the next frame may be read.
+type DecodeError struct {
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return "delta: decode: " + e.Err.Error()
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// Reader reads length prefixed Messages from a stream.
+type Reader struct {
+	r   *bufio.Reader
+	max int
+	buf []byte
+}
+
+// NewReader returns a Reader limited to MaxFrameSize.
+func NewReader(r io.Reader) *Reader {
+	return NewReaderSize(r, MaxFrameSize)
+}
+
+// NewReaderSize returns a Reader that rejects frames larger than max bytes.
+func NewReaderSize(r io.Reader, max int) *Reader {
+	return &Reader{
+		r:   bufio.NewReader(r),
+		max: max,
+	}
+}
+
+// Read reads exactly one frame into msg. It returns io.EOF only if the stream
+// ended cleanly between frames.
+func (r *Reader) Read(msg *Message) error {
+	size, err := binary.ReadUvarint(r.r)
+	switch {
+	case err == io.EOF:
+		return io.EOF
+	case err == io.ErrUnexpectedEOF:
+		return &TruncatedFrameError{Size: -1}
+	case err != nil:
+		return err
+	}
+	if size > uint64(r.max) {
+		return &FrameSizeError{Size: size, Max: r.max}
+	}
+
+	if cap(r.buf) < int(size) {
+		r.buf = make([]byte, size)
+	}
+	data := r.buf[:size]
+	if n, err := io.ReadFull(r.r, data); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return &TruncatedFrameError{Size: int(size), Read: n}
+		}
+		return err
+	}
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return &DecodeError{Err: err}
+	}
+	return nil
+}
+
+// Writer writes length prefixed Messages to a stream. Each frame is passed to
+// the underlying writer in a single Write call.
+type Writer struct {
+	w   io.Writer
+	max int
+	buf []byte
+}
+
+// NewWriter returns a Writer limited to MaxFrameSize.
+func NewWriter(w io.Writer) *Writer {
+	return NewWriterSize(w, MaxFrameSize)
+}
+
+// NewWriterSize returns a Writer that refuses to send messages larger than
+// max bytes.
+func NewWriterSize(w io.Writer, max int) *Writer {
+	return &Writer{
+		w:   w,
+		max: max,
+	}
+}
+
+// Write marshals msg and writes it as a single frame.
+func (w *Writer) Write(msg *Message) error {
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if len(data) > w.max {
+		return &FrameSizeError{Size: uint64(len(data)), Max: w.max}
+	}
+
+	w.buf = w.buf[:0]
+	w.buf = binary.AppendUvarint(w.buf, uint64(len(data)))
+	w.buf = append(w.buf, data...)
+	_, err = w.w.Write(w.buf)
+	return err
+}
@@ -0,0 +1,126 @@
+package delta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	msgs := []*Message{
+		{Type: Message_PING.Enum()},
+		{Type: Message_PING.Enum(), Info: proto.String("Hello, world!")},
+		{Type: Message_POINT.Enum(), Point: &Point{X: proto.Float64(0.01), Y: proto.Float64(-0.02), Z: proto.Float64(0)}},
+		{Type: Message_SET.Enum(), Id: proto.Uint32(7), Motor: &Motor{Id: proto.Int32(2), P: proto.Int32(32)}},
+		// Long enough for a two byte length prefix
+		{Type: Message_ERROR.Enum(), Info: proto.String(string(bytes.Repeat([]byte("x"), 200)))},
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	for _, m := range msgs {
+		if err := w.Write(m); err != nil {
+			t.Fatalf("Write(%v): %v", m, err)
+		}
+	}
+
+	r := NewReader(&buf)
+	for i, want := range msgs {
+		got := &Message{}
+		if err := r.Read(got); err != nil {
+			t.Fatalf("Read %d: %v", i, err)
+		}
+		if !proto.Equal(got, want) {
+			t.Errorf("Read %d = %v, want %v", i, got, want)
+		}
+	}
+	if err := r.Read(&Message{}); err != io.EOF {
+		t.Errorf("Read at end = %v, want io.EOF", err)
+	}
+}
+
+// frame returns data with a length prefix of size.
+func frame(size uint64, data []byte) []byte {
+	return append(binary.AppendUvarint(nil, size), data...)
+}
+
+func TestFrameShort(t *testing.T) {
+	ping, err := proto.Marshal(&Message{Type: Message_PING.Enum(), Info: proto.String("ping")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		data []byte
+		want TruncatedFrameError
+	}{
+		{"prefix", []byte{0x80}, TruncatedFrameError{Size: -1}},
+		{"empty body", frame(uint64(len(ping)), nil), TruncatedFrameError{Size: len(ping), Read: 0}},
+		{"body", frame(uint64(len(ping)), ping[:3]), TruncatedFrameError{Size: len(ping), Read: 3}},
+	} {
+		err := NewReader(bytes.NewReader(tt.data)).Read(&Message{})
+		var terr *TruncatedFrameError
+		if !errors.As(err, &terr) {
+			t.Errorf("%s: Read = %v, want *TruncatedFrameError", tt.name, err)
+			continue
+		}
+		if *terr != tt.want {
+			t.Errorf("%s: Read = %+v, want %+v", tt.name, *terr, tt.want)
+		}
+	}
+}
+
+func TestFrameOversized(t *testing.T) {
+	big := &Message{Type: Message_ERROR.Enum(), Info: proto.String(string(bytes.Repeat([]byte("x"), MaxFrameSize)))}
+
+	var buf bytes.Buffer
+	err := NewWriter(&buf).Write(big)
+	var serr *FrameSizeError
+	if !errors.As(err, &serr) || serr.Max != MaxFrameSize {
+		t.Errorf("Write = %v, want *FrameSizeError with max %d", err, MaxFrameSize)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Write wrote %d bytes of an oversized frame", buf.Len())
+	}
+
+	// A larger writer limit gets it on the wire for the reader to refuse
+	if err := NewWriterSize(&buf, 2*MaxFrameSize).Write(big); err != nil {
+		t.Fatal(err)
+	}
+	err = NewReader(&buf).Read(&Message{})
+	if !errors.As(err, &serr) || serr.Max != MaxFrameSize || serr.Size <= MaxFrameSize {
+		t.Errorf("Read = %v, want *FrameSizeError", err)
+	}
+
+	// The limit applies to the prefix before anything is allocated
+	huge := binary.AppendUvarint(nil, 1<<40)
+	if err := NewReader(bytes.NewReader(huge)).Read(&Message{}); !errors.As(err, &serr) {
+		t.Errorf("Read of 1<<40 byte frame = %v, want *FrameSizeError", err)
+	}
+}
+
+func TestFrameDecodeError(t *testing.T) {
+	var buf bytes.Buffer
+	buf.Write(frame(2, []byte{0xff, 0xff})) // invalid wire type
+	if err := NewWriter(&buf).Write(&Message{Type: Message_PING.Enum()}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewReader(&buf)
+	var derr *DecodeError
+	if err := r.Read(&Message{}); !errors.As(err, &derr) {
+		t.Fatalf("Read of bad message = %v, want *DecodeError", err)
+	}
+	msg := &Message{}
+	if err := r.Read(msg); err != nil {
+		t.Fatalf("Read after DecodeError: %v", err)
+	}
+	if msg.GetType() != Message_PING {
+		t.Errorf("Read after DecodeError = %v, want PING", msg)
+	}
+}
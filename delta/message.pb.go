@@ -25,13 +25,14 @@ var _ = math.Inf
 type Message_Type int32
 
 const (
-	Message_ERROR Message_Type = 1
-	Message_START Message_Type = 2
-	Message_STOP  Message_Type = 3
-	Message_PING  Message_Type = 4
-	Message_POINT Message_Type = 5
-	Message_SET   Message_Type = 6
-	Message_GET   Message_Type = 7
+	Message_ERROR     Message_Type = 1
+	Message_START     Message_Type = 2
+	Message_STOP      Message_Type = 3
+	Message_PING      Message_Type = 4
+	Message_POINT     Message_Type = 5
+	Message_SET       Message_Type = 6
+	Message_GET       Message_Type = 7
+	Message_HEARTBEAT Message_Type = 8
 )
 
 var Message_Type_name = map[int32]string{
@@ -42,15 +43,17 @@ var Message_Type_name = map[int32]string{
 	5: "POINT",
 	6: "SET",
 	7: "GET",
+	8: "HEARTBEAT",
 }
 var Message_Type_value = map[string]int32{
-	"ERROR": 1,
-	"START": 2,
-	"STOP":  3,
-	"PING":  4,
-	"POINT": 5,
-	"SET":   6,
-	"GET":   7,
+	"ERROR":     1,
+	"START":     2,
+	"STOP":      3,
+	"PING":      4,
+	"POINT":     5,
+	"SET":       6,
+	"GET":       7,
+	"HEARTBEAT": 8,
 }
 
 func (x Message_Type) Enum() *Message_Type {
@@ -70,13 +73,56 @@ func (x *Message_Type) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+type Message_Status int32
+
+const (
+	Message_OK      Message_Status = 1
+	Message_INVALID Message_Status = 2
+	Message_IGNORED Message_Status = 3
+)
+
+var Message_Status_name = map[int32]string{
+	1: "OK",
+	2: "INVALID",
+	3: "IGNORED",
+}
+var Message_Status_value = map[string]int32{
+	"OK":      1,
+	"INVALID": 2,
+	"IGNORED": 3,
+}
+
+func (x Message_Status) Enum() *Message_Status {
+	p := new(Message_Status)
+	*p = x
+	return p
+}
+func (x Message_Status) String() string {
+	return proto.EnumName(Message_Status_name, int32(x))
+}
+func (x *Message_Status) UnmarshalJSON(data []byte) error {
+	value, err := proto.UnmarshalJSONEnum(Message_Status_value, data, "Message_Status")
+	if err != nil {
+		return err
+	}
+	*x = Message_Status(value)
+	return nil
+}
+
 type Message struct {
 	// Type Identifier
-	Type             *Message_Type `protobuf:"varint,1,req,name=type,enum=delta.Message_Type" json:"type,omitempty"`
-	Info             *string       `protobuf:"bytes,2,opt,name=info" json:"info,omitempty"`
-	Point            *Point        `protobuf:"bytes,3,opt,name=point" json:"point,omitempty"`
-	Motor            *Motor        `protobuf:"bytes,5,opt,name=motor" json:"motor,omitempty"`
-	XXX_unrecognized []byte        `json:"-"`
+	Type  *Message_Type `protobuf:"varint,1,req,name=type,enum=delta.Message_Type" json:"type,omitempty"`
+	Info  *string       `protobuf:"bytes,2,opt,name=info" json:"info,omitempty"`
+	Point *Point        `protobuf:"bytes,3,opt,name=point" json:"point,omitempty"`
+	Motor *Motor        `protobuf:"bytes,5,opt,name=motor" json:"motor,omitempty"`
+	// Request ID, echoed on the reply. Requests with an ID are always
+	// answered, by an ERROR or a reply of the same type carrying a status.
+	Id     *uint32         `protobuf:"varint,6,opt,name=id" json:"id,omitempty"`
+	Status *Message_Status `protobuf:"varint,7,opt,name=status,enum=delta.Message_Status" json:"status,omitempty"`
+	// HEARTBEAT watchdog in milliseconds. The arm stops itself if no
+	// heartbeat arrives within the watchdog of the last, 0 disarms it.
+	Watchdog         *uint32 `protobuf:"varint,8,opt,name=watchdog" json:"watchdog,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
 }
 
 func (m *Message) Reset()         { *m = Message{} }
@@ -111,6 +157,27 @@ func (m *Message) GetMotor() *Motor {
 	return nil
 }
 
+func (m *Message) GetId() uint32 {
+	if m != nil && m.Id != nil {
+		return *m.Id
+	}
+	return 0
+}
+
+func (m *Message) GetStatus() Message_Status {
+	if m != nil && m.Status != nil {
+		return *m.Status
+	}
+	return Message_OK
+}
+
+func (m *Message) GetWatchdog() uint32 {
+	if m != nil && m.Watchdog != nil {
+		return *m.Watchdog
+	}
+	return 0
+}
+
 type Point struct {
 	X                *float64 `protobuf:"fixed64,1,req,name=x" json:"x,omitempty"`
 	Y                *float64 `protobuf:"fixed64,2,req,name=y" json:"y,omitempty"`
@@ -217,4 +284,5 @@ func (m *Motor) GetPunch() int32 {
 
 func init() {
 	proto.RegisterEnum("delta.Message_Type", Message_Type_name, Message_Type_value)
+	proto.RegisterEnum("delta.Message_Status", Message_Status_name, Message_Status_value)
 }
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/afking/godelta/safety"
+	"github.com/codegangsta/cli"
+)
+
+var (
+	// halt stops the arm while a safe command runs
+	halt *safety.EStop
+
+	// heart is beaten by control loops while a safe command runs
+	heart *safety.Heart
+)
+
+// safe wraps commands that move the arm: STOP is sent on SIGINT, SIGTERM or
+// a panic, and heartbeats are sent while the command's control loop beats
+// the heart
+func safe(f func(*cli.Context) error) func(*cli.Context) error {
+	return func(c *cli.Context) error {
+		heart = safety.NewHeart(cfg.Heartbeat, cfg.Watchdog, func(w time.Duration) error {
+			ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+			defer cancel()
+			return arm.Heartbeat(ctx, w)
+		})
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			heart.Run(ctx)
+			close(done)
+		}()
+		defer func() {
+			cancel()
+			<-done
+		}()
+
+		halt = safety.NewEStop(arm.EStop)
+		defer halt.Notify()()
+		defer halt.Recover()
+		return f(c)
+	}
+}
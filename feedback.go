@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/afking/godelta/delta"
+	"github.com/afking/godelta/gamepad"
+)
+
+// Rumble and LED timing
+const (
+	clampRumble = 150 * time.Millisecond
+	errorRumble = 400 * time.Millisecond
+	errorLED    = 2 * time.Second // error pattern after the last ERROR
+)
+
+// feedback shows the arm's state on a pad: the LED ring flashes all when
+// the arm is unreachable, slow flashes when stopped, squiggles after an
+// ERROR and otherwise shows the speed, and the pad rumbles when a point is
+// clamped to the workspace or the arm reports an ERROR. Output is written
+// only by run.
+type feedback struct {
+	lights  gamepad.Lights  // nil if the pad has none
+	rumbler gamepad.Rumbler // nil if the pad has none
+
+	mu           sync.Mutex
+	speed        int // index into speeds
+	stopped      bool
+	errAt        time.Time
+	strong, weak motor
+}
+
+// motor is a rumble motor's level until a deadline
+type motor struct {
+	level float64
+	until time.Time
+}
+
+// add runs the motor at least at level until at least until
+func (m *motor) add(now, until time.Time, level float64) {
+	if level <= 0 {
+		return
+	}
+	if !now.Before(m.until) {
+		m.level = 0
+	}
+	m.level = math.Max(m.level, level)
+	if until.After(m.until) {
+		m.until = until
+	}
+}
+
+// at returns the level at now
+func (m *motor) at(now time.Time) float64 {
+	if !now.Before(m.until) {
+		return 0
+	}
+	return m.level
+}
+
+func newFeedback(pad gamepad.InputSource) *feedback {
+	f := &feedback{}
+	f.lights, _ = pad.(gamepad.Lights)
+	f.rumbler, _ = pad.(gamepad.Rumbler)
+	return f
+}
+
+func (f *feedback) setSpeed(i int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.speed = i
+}
+
+func (f *feedback) setStopped(stopped bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stopped = stopped
+}
+
+// clamped gives a short, light rumble
+func (f *feedback) clamped(now time.Time) {
+	f.buzz(now, clampRumble, 0, 0.6)
+}
+
+// armError gives a long, heavy rumble and shows the error pattern
+func (f *feedback) armError(now time.Time) {
+	f.mu.Lock()
+	f.errAt = now
+	f.mu.Unlock()
+	f.buzz(now, errorRumble, 1, 0)
+}
+
+// buzz runs each motor at least at its level for d, so a light buzz does not
+// cut short a heavy one
+func (f *feedback) buzz(now time.Time, d time.Duration, strong, weak float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.strong.add(now, now.Add(d), strong)
+	f.weak.add(now, now.Add(d), weak)
+}
+
+// led returns the pattern for the state at now
+func (f *feedback) led(now time.Time) gamepad.LED {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case arm.RemoteAddr() == nil:
+		return gamepad.WarnAll // reconnecting
+	case f.stopped || halt.Triggered():
+		return gamepad.Searching
+	case !f.errAt.IsZero() && now.Sub(f.errAt) < errorLED:
+		return gamepad.Battery
+	}
+	return gamepad.Player1 + gamepad.LED(f.speed)
+}
+
+// rumble returns the motor levels at now
+func (f *feedback) rumble(now time.Time) (strong, weak float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.strong.at(now), f.weak.at(now)
+}
+
+// run updates the pad until ctx is done, then stops any rumble
+func (f *feedback) run(ctx context.Context) {
+	if f.lights == nil && f.rumbler == nil {
+		return
+	}
+	tick := time.NewTicker(50 * time.Millisecond)
+	defer tick.Stop()
+
+	var led gamepad.LED = 0xff
+	var strong, weak float64
+	for {
+		now := time.Now()
+		if f.lights != nil {
+			if l := f.led(now); l != led {
+				f.lights.LED(l)
+				led = l
+			}
+		}
+		if f.rumbler != nil {
+			if s, w := f.rumble(now); s != strong || w != weak {
+				f.rumbler.Rumble(s, w)
+				strong, weak = s, w
+			}
+		}
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			if f.rumbler != nil && (strong != 0 || weak != 0) {
+				f.rumbler.Rumble(0, 0)
+			}
+			return
+		}
+	}
+}
+
+// watchErrors reports ERROR messages from the arm until ctx is done
+func (f *feedback) watchErrors(ctx context.Context) {
+	for {
+		msg, err := arm.Recv(ctx)
+		if err != nil {
+			return
+		}
+		if msg.GetType() == delta.Message_ERROR {
+			log.Println("xbox: arm error: ", msg.GetInfo())
+			f.armError(time.Now())
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/afking/godelta/gamepad"
+)
+
+func TestFeedbackRumble(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	at := func(d time.Duration) time.Time { return t0.Add(d) }
+	ms := time.Millisecond
+
+	f := &feedback{}
+	for _, tt := range []struct {
+		do           func()
+		now          time.Duration
+		strong, weak float64
+	}{
+		{nil, 0, 0, 0},
+		{func() { f.armError(at(0)) }, 0, 1, 0},
+		// A clamp during the error rumble adds to it rather than replacing it
+		{func() { f.clamped(at(100 * ms)) }, 100 * ms, 1, 0.6},
+		{nil, 249 * ms, 1, 0.6},
+		{nil, 250 * ms, 1, 0},
+		{nil, 399 * ms, 1, 0},
+		{nil, 400 * ms, 0, 0},
+		// Nor does an error cut short a clamp
+		{func() { f.clamped(at(time.Second)) }, time.Second, 0, 0.6},
+		{func() { f.armError(at(time.Second + 50*ms)) }, time.Second + 50*ms, 1, 0.6},
+		{nil, time.Second + 150*ms, 1, 0},
+		// Repeated clamps extend the buzz
+		{func() { f.clamped(at(2 * time.Second)) }, 2 * time.Second, 0, 0.6},
+		{func() { f.clamped(at(2*time.Second + 100*ms)) }, 2*time.Second + 200*ms, 0, 0.6},
+		{nil, 2*time.Second + 250*ms, 0, 0},
+	} {
+		if tt.do != nil {
+			tt.do()
+		}
+		if s, w := f.rumble(at(tt.now)); s != tt.strong || w != tt.weak {
+			t.Errorf("rumble at %v = %v, %v, want %v, %v", tt.now, s, w, tt.strong, tt.weak)
+		}
+	}
+}
+
+func TestFeedbackLED(t *testing.T) {
+	simArm(t)
+	now := time.Now()
+	f := &feedback{}
+	f.setSpeed(2)
+	if l := f.led(now); l != gamepad.Player3 {
+		t.Errorf("LED at speed 2 = %v, want Player3", l)
+	}
+
+	f.armError(now)
+	if l := f.led(now.Add(time.Second)); l != gamepad.Battery {
+		t.Errorf("LED after an error = %v, want Battery", l)
+	}
+	if l := f.led(now.Add(errorLED)); l != gamepad.Player3 {
+		t.Errorf("LED %v after an error = %v, want Player3", errorLED, l)
+	}
+
+	f.setStopped(true)
+	if l := f.led(now); l != gamepad.Searching {
+		t.Errorf("LED when stopped = %v, want Searching", l)
+	}
+	f.setStopped(false)
+	halt.Trigger("test")
+	if l := f.led(now); l != gamepad.Searching {
+		t.Errorf("LED after the e-stop = %v, want Searching", l)
+	}
+
+	arm.Close()
+	if l := f.led(now); l != gamepad.WarnAll {
+		t.Errorf("LED while disconnected = %v, want WarnAll", l)
+	}
+}
+
+// fakePad records the LED and rumble output it is sent.
+type fakePad struct {
+	gamepad.Chan
+
+	mu     sync.Mutex
+	leds   []gamepad.LED
+	rumble [][2]float64
+}
+
+func (p *fakePad) LED(l gamepad.LED) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.leds = append(p.leds, l)
+	return nil
+}
+
+func (p *fakePad) Rumble(strong, weak float64) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rumble = append(p.rumble, [2]float64{strong, weak})
+	return nil
+}
+
+// last returns the last LED pattern and rumble levels sent.
+func (p *fakePad) last() (gamepad.LED, [2]float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var l gamepad.LED = 0xff
+	var r [2]float64
+	if len(p.leds) > 0 {
+		l = p.leds[len(p.leds)-1]
+	}
+	if len(p.rumble) > 0 {
+		r = p.rumble[len(p.rumble)-1]
+	}
+	return l, r
+}
+
+func TestFeedbackRun(t *testing.T) {
+	simArm(t)
+	pad := &fakePad{}
+	f := newFeedback(pad)
+	f.setSpeed(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		f.run(ctx)
+		close(done)
+	}()
+
+	waitFor(t, "the speed LED", func() bool {
+		l, _ := pad.last()
+		return l == gamepad.Player2
+	})
+	f.buzz(time.Now(), time.Minute, 0.5, 0.25)
+	waitFor(t, "rumble", func() bool {
+		_, r := pad.last()
+		return r == [2]float64{0.5, 0.25}
+	})
+
+	// Stopping turns the motors off
+	cancel()
+	<-done
+	if _, r := pad.last(); r != [2]float64{} {
+		t.Errorf("rumble after run = %v, want off", r)
+	}
+}
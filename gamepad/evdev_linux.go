@@ -0,0 +1,330 @@
+package gamepad
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Device is an input device found by Devices.
+type Device struct {
+	Name            string
+	Vendor, Product uint16
+	Path            string // /dev/input/eventN
+}
+
+func (d Device) String() string {
+	return fmt.Sprintf("%s %04x:%04x %q", d.Path, d.Vendor, d.Product, d.Name)
+}
+
+// Devices lists the joysticks and pads known to the kernel.
+func Devices() ([]Device, error) {
+	f, err := os.Open("/proc/bus/input/devices")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// Blocks of "X: ..." lines separated by blank lines
+	var ds []Device
+	var d Device
+	js := false
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case line == "":
+			if js && d.Path != "" {
+				ds = append(ds, d)
+			}
+			d, js = Device{}, false
+		case strings.HasPrefix(line, "I:"):
+			for _, kv := range strings.Fields(line[2:]) {
+				k, v, _ := strings.Cut(kv, "=")
+				n, _ := strconv.ParseUint(v, 16, 16)
+				switch k {
+				case "Vendor":
+					d.Vendor = uint16(n)
+				case "Product":
+					d.Product = uint16(n)
+				}
+			}
+		case strings.HasPrefix(line, "N: Name="):
+			d.Name = strings.Trim(line[len("N: Name="):], `"`)
+		case strings.HasPrefix(line, "H: Handlers="):
+			for _, h := range strings.Fields(line[len("H: Handlers="):]) {
+				if strings.HasPrefix(h, "event") {
+					d.Path = "/dev/input/" + h
+				}
+				if strings.HasPrefix(h, "js") {
+					js = true
+				}
+			}
+		}
+	}
+	if js && d.Path != "" {
+		ds = append(ds, d)
+	}
+	return ds, sc.Err()
+}
+
+// FindDevice returns the first pad matching match: a /dev/input path, a
+// vendor:product pair in hex such as "045e:028e", or a case insensitive
+// substring of the name. An empty match finds the first pad.
+func FindDevice(match string) (Device, error) {
+	if strings.HasPrefix(match, "/dev/") {
+		return Device{Path: match}, nil
+	}
+	ds, err := Devices()
+	if err != nil {
+		return Device{}, err
+	}
+	for _, d := range ds {
+		if match == "" ||
+			fmt.Sprintf("%04x:%04x", d.Vendor, d.Product) == strings.ToLower(match) ||
+			strings.Contains(strings.ToLower(d.Name), strings.ToLower(match)) {
+			return d, nil
+		}
+	}
+	if match == "" {
+		return Device{}, errors.New("gamepad: no joystick devices found")
+	}
+	return Device{}, fmt.Errorf("gamepad: no joystick device matches %q", match)
+}
+
+// Event types and codes from linux/input-event-codes.h.
+const (
+	evSyn = 0x00
+	evKey = 0x01
+	evAbs = 0x03
+
+	synReport  = 0x00
+	synDropped = 0x03
+
+	absX     = 0x00
+	absY     = 0x01
+	absZ     = 0x02
+	absRX    = 0x03
+	absRY    = 0x04
+	absRZ    = 0x05
+	absHat0X = 0x10
+	absHat0Y = 0x11
+	absCount = 0x40
+)
+
+// evdevButtons maps key codes to buttons. Pads without a hat report the
+// d-pad as buttons.
+var evdevButtons = map[uint16]Button{
+	0x130: A,  // BTN_SOUTH
+	0x131: B,  // BTN_EAST
+	0x133: X,  // BTN_NORTH
+	0x134: Y,  // BTN_WEST
+	0x136: LB, // BTN_TL
+	0x137: RB, // BTN_TR
+	0x13a: Back,
+	0x13b: Start,
+	0x13c: Guide, // BTN_MODE
+	0x13d: LeftThumb,
+	0x13e: RightThumb,
+	0x220: Up, // BTN_DPAD_*
+	0x221: Down,
+	0x222: Left,
+	0x223: Right,
+}
+
+// absInfo is struct input_absinfo.
+type absInfo struct {
+	Value, Min, Max, Fuzz, Flat, Resolution int32
+}
+
+// eviocgabs is EVIOCGABS(code), _IOR('E', 0x40 + code, struct input_absinfo).
+func eviocgabs(code int) uintptr {
+	return 2<<30 | uintptr(unsafe.Sizeof(absInfo{}))<<16 | 'E'<<8 | uintptr(0x40+code)
+}
+
+// Evdev is a pad read through the Linux event interface, which works beside
+// the kernel driver and needs only read access to the device node.
+type Evdev struct {
+	f       *os.File
+	timeout time.Duration
+	abs     [absCount]absInfo
+	buf     []byte
+
+	state    State // being updated by events
+	last     State // at the last report
+	dropping bool  // events were lost, skip to the next report
+}
+
+// input_event is a struct timeval, two longs, then type, code and value.
+var eventSize = 2*int(unsafe.Sizeof(uintptr(0))) + 8
+
+// OpenEvdev opens the event device at path. Reads time out after timeout
+// with the state unchanged, or block if it is 0.
+func OpenEvdev(path string, timeout time.Duration) (*Evdev, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	e := &Evdev{f: f, timeout: timeout, buf: make([]byte, 64*eventSize)}
+	if err := e.readAbs(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	e.last = e.state
+	return e, nil
+}
+
+// readAbs reads the range and value of every absolute axis.
+func (e *Evdev) readAbs() error {
+	// Not f.Fd, which would make reads blocking and ignore deadlines
+	rc, err := e.f.SyscallConn()
+	if err != nil {
+		return fmt.Errorf("gamepad: read axes: %v", err)
+	}
+	var gone bool
+	err = rc.Control(func(fd uintptr) {
+		for code := range e.abs {
+			var a absInfo
+			_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, eviocgabs(code), uintptr(unsafe.Pointer(&a)))
+			if errno == syscall.ENODEV {
+				gone = true
+				return
+			}
+			if errno != 0 {
+				continue // not reported by this device
+			}
+			e.abs[code] = a
+			e.set(uint16(code), a.Value)
+		}
+	})
+	switch {
+	case err != nil:
+		return fmt.Errorf("gamepad: read axes: %v", err)
+	case gone:
+		return ErrDisconnected
+	}
+	return nil
+}
+
+// Close releases the device.
+func (e *Evdev) Close() error {
+	return e.f.Close()
+}
+
+// Read returns the state after the next complete report.
+func (e *Evdev) Read() (State, error) {
+	for {
+		if e.timeout > 0 {
+			e.f.SetReadDeadline(time.Now().Add(e.timeout))
+		}
+		n, err := e.f.Read(e.buf)
+		switch {
+		case errors.Is(err, os.ErrDeadlineExceeded):
+			return e.last, nil // idle
+		case errors.Is(err, syscall.ENODEV):
+			return e.last, ErrDisconnected
+		case err != nil:
+			return e.last, fmt.Errorf("gamepad: read: %v", err)
+		}
+
+		report := false
+		for b := e.buf[:n]; len(b) >= eventSize; b = b[eventSize:] {
+			ev := b[eventSize-8:]
+			typ := binary.LittleEndian.Uint16(ev[0:])
+			code := binary.LittleEndian.Uint16(ev[2:])
+			value := int32(binary.LittleEndian.Uint32(ev[4:]))
+
+			if e.dropping {
+				if typ == evSyn && code == synReport {
+					// Keys catch up on their next edge
+					e.dropping = false
+					e.state = e.last
+					if err := e.readAbs(); err != nil {
+						return e.last, err
+					}
+				}
+				continue
+			}
+			switch typ {
+			case evKey:
+				if btn, ok := evdevButtons[code]; ok {
+					if value != 0 {
+						e.state.Buttons |= btn
+					} else {
+						e.state.Buttons &^= btn
+					}
+				}
+			case evAbs:
+				e.set(code, value)
+			case evSyn:
+				switch code {
+				case synReport:
+					e.last, report = e.state, true
+				case synDropped:
+					e.dropping = true
+				}
+			}
+		}
+		if report {
+			return e.last, nil
+		}
+	}
+}
+
+// set records an absolute axis value.
+func (e *Evdev) set(code uint16, v int32) {
+	if int(code) >= len(e.abs) {
+		return
+	}
+	a := e.abs[code]
+	stick := func() float64 {
+		if a.Max <= a.Min {
+			return 0
+		}
+		c := (float64(a.Min) + float64(a.Max)) / 2
+		x := (float64(v) - c) / ((float64(a.Max) - float64(a.Min)) / 2)
+		return clamp(x, -1, 1)
+	}
+	trigger := func() float64 {
+		if a.Max <= a.Min {
+			return 0
+		}
+		return clamp((float64(v)-float64(a.Min))/(float64(a.Max)-float64(a.Min)), 0, 1)
+	}
+	// Event y axes point down; State is up positive
+	switch code {
+	case absX:
+		e.state.LX = stick()
+	case absY:
+		e.state.LY = -stick()
+	case absRX:
+		e.state.RX = stick()
+	case absRY:
+		e.state.RY = -stick()
+	case absZ:
+		e.state.LT = trigger()
+	case absRZ:
+		e.state.RT = trigger()
+	case absHat0X:
+		e.state.Buttons &^= Left | Right
+		if v < 0 {
+			e.state.Buttons |= Left
+		} else if v > 0 {
+			e.state.Buttons |= Right
+		}
+	case absHat0Y:
+		e.state.Buttons &^= Up | Down
+		if v < 0 {
+			e.state.Buttons |= Up
+		} else if v > 0 {
+			e.state.Buttons |= Down
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package gamepad
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"testing"
+	"time"
+)
+
+// Key codes used by the tests.
+const (
+	btnSouth = 0x130
+	btnEast  = 0x131
+	btnNorth = 0x133
+	btnWest  = 0x134
+)
+
+// event encodes an input_event. The timestamp is filled with junk, which
+// Read ignores.
+func event(typ, code uint16, value int32) []byte {
+	b := make([]byte, eventSize)
+	for i := range b[:eventSize-8] {
+		b[i] = 0xa5
+	}
+	ev := b[eventSize-8:]
+	binary.LittleEndian.PutUint16(ev[0:], typ)
+	binary.LittleEndian.PutUint16(ev[2:], code)
+	binary.LittleEndian.PutUint32(ev[4:], uint32(value))
+	return b
+}
+
+func report() []byte { return event(evSyn, synReport, 0) }
+
+// pipeEvdev returns an Evdev reading from a pipe, with the axis ranges of
+// an Xbox 360 pad, and the pipe's write end.
+func pipeEvdev(t *testing.T, timeout time.Duration) (*Evdev, *os.File) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close(); w.Close() })
+	e := &Evdev{f: r, timeout: timeout, buf: make([]byte, 64*eventSize)}
+	for _, code := range []int{absX, absY, absRX, absRY} {
+		e.abs[code] = absInfo{Min: -32768, Max: 32767}
+	}
+	e.abs[absZ] = absInfo{Max: 255}
+	e.abs[absRZ] = absInfo{Max: 255}
+	e.abs[absHat0X] = absInfo{Min: -1, Max: 1}
+	e.abs[absHat0Y] = absInfo{Min: -1, Max: 1}
+	return e, w
+}
+
+// write sends events down the pipe in one write.
+func write(t *testing.T, w *os.File, evs ...[]byte) {
+	t.Helper()
+	var b []byte
+	for _, ev := range evs {
+		b = append(b, ev...)
+	}
+	if _, err := w.Write(b); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEvdevRead(t *testing.T) {
+	e, w := pipeEvdev(t, 0)
+	write(t, w,
+		event(evKey, btnSouth, 1),
+		event(evKey, 0x2ff, 1), // not a pad button
+		event(evAbs, absX, 32767),
+		event(evAbs, absY, -32768),
+		event(evAbs, absZ, 255),
+		event(evAbs, absHat0X, -1),
+		event(evAbs, absHat0Y, 1),
+		event(evAbs, absCount+1, 5), // out of range
+		event(0x02, 0, 1),           // EV_REL, not handled
+		report(),
+	)
+	s, err := e.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := State{Buttons: A | Left | Down, LX: 1, LY: 1, LT: 1}
+	if s != want {
+		t.Errorf("Read = %+v, want %+v", s, want)
+	}
+
+	// Changes build on the last report
+	write(t, w,
+		event(evKey, btnSouth, 0),
+		event(evKey, btnEast, 1),
+		event(evAbs, absHat0X, 0),
+		event(evAbs, absZ, 0),
+		report(),
+	)
+	if s, err = e.Read(); err != nil {
+		t.Fatal(err)
+	}
+	want = State{Buttons: B | Down, LX: 1, LY: 1}
+	if s != want {
+		t.Errorf("Read = %+v, want %+v", s, want)
+	}
+
+	// Two reports in one read return the later
+	write(t, w, event(evKey, btnNorth, 1), report(), event(evKey, btnWest, 1), report())
+	if s, err = e.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if want := B | X | Y | Down; s.Buttons != want {
+		t.Errorf("Read buttons = %v, want %v", s.Buttons, want)
+	}
+}
+
+func TestEvdevTimeout(t *testing.T) {
+	e, w := pipeEvdev(t, 10*time.Millisecond)
+	write(t, w, event(evKey, btnSouth, 1), report())
+	if _, err := e.Read(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Events without a report are not returned
+	write(t, w, event(evKey, btnSouth, 0), event(evAbs, absX, 32767))
+	s, err := e.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (State{Buttons: A}); s != want {
+		t.Errorf("Read with no report = %+v, want the last %+v", s, want)
+	}
+	write(t, w, report())
+	if s, err = e.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if want := (State{LX: 1}); s != want {
+		t.Errorf("Read = %+v, want %+v", s, want)
+	}
+
+	w.Close()
+	if _, err := e.Read(); err == nil {
+		t.Error("Read after the writer closed succeeded")
+	}
+}
+
+func TestEvdevDropped(t *testing.T) {
+	e, w := pipeEvdev(t, 0)
+	write(t, w, event(evKey, btnSouth, 1), event(evAbs, absX, 32767), report())
+	if _, err := e.Read(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Everything from the partial report before SYN_DROPPED up to the next
+	// report is thrown away, and reading picks up after it
+	write(t, w,
+		event(evKey, btnEast, 1),
+		event(evSyn, synDropped, 0),
+		event(evKey, btnNorth, 1),
+		event(evAbs, absX, -32768),
+		report(),
+		event(evKey, btnWest, 1),
+		report(),
+	)
+	s, err := e.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (State{Buttons: A | Y, LX: 1}); s != want {
+		t.Errorf("Read = %+v, want %+v", s, want)
+	}
+
+	// A drop across reads is skipped until its report arrives
+	write(t, w, event(evSyn, synDropped, 0), event(evKey, btnEast, 1))
+	write(t, w, event(evAbs, absY, 32767), report(), event(evKey, btnSouth, 0), report())
+	if s, err = e.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if want := (State{Buttons: Y, LX: 1}); s != want {
+		t.Errorf("Read = %+v, want %+v", s, want)
+	}
+}
+
+func TestEvdevResyncError(t *testing.T) {
+	e, _ := pipeEvdev(t, 0)
+	e.f.Close()
+	if err := e.readAbs(); err == nil {
+		t.Error("readAbs of a closed device succeeded")
+	}
+}
+
+func TestEvdevScale(t *testing.T) {
+	const tolerance = 1e-4
+	for _, tt := range []struct {
+		name string
+		code int
+		abs  absInfo
+		v    int32
+		get  func(State) float64
+		want float64
+	}{
+		{"stick centre", absX, absInfo{Min: -32768, Max: 32767}, 0, func(s State) float64 { return s.LX }, 0},
+		{"stick left", absX, absInfo{Min: -32768, Max: 32767}, -32768, func(s State) float64 { return s.LX }, -1},
+		{"stick right", absRX, absInfo{Min: -32768, Max: 32767}, 32767, func(s State) float64 { return s.RX }, 1},
+		{"stick half", absRX, absInfo{Min: -32768, Max: 32767}, 16384, func(s State) float64 { return s.RX }, 0.5},
+		{"stick up", absY, absInfo{Min: -32768, Max: 32767}, -32768, func(s State) float64 { return s.LY }, 1},
+		{"stick down", absRY, absInfo{Min: -32768, Max: 32767}, 32767, func(s State) float64 { return s.RY }, -1},
+		{"unsigned stick centre", absX, absInfo{Max: 255}, 128, func(s State) float64 { return s.LX }, 0.0039},
+		{"unsigned stick low", absX, absInfo{Max: 255}, 0, func(s State) float64 { return s.LX }, -1},
+		{"past the range", absX, absInfo{Min: -100, Max: 100}, 150, func(s State) float64 { return s.LX }, 1},
+		{"past the negative range", absX, absInfo{Min: -100, Max: 100}, -150, func(s State) float64 { return s.LX }, -1},
+		{"trigger released", absZ, absInfo{Max: 1023}, 0, func(s State) float64 { return s.LT }, 0},
+		{"trigger half", absRZ, absInfo{Max: 1023}, 512, func(s State) float64 { return s.RT }, 0.5005},
+		{"trigger full", absRZ, absInfo{Max: 1023}, 1023, func(s State) float64 { return s.RT }, 1},
+		{"trigger past the range", absZ, absInfo{Min: 10, Max: 20}, 5, func(s State) float64 { return s.LT }, 0},
+		{"no range", absX, absInfo{}, 100, func(s State) float64 { return s.LX }, 0},
+		{"backwards range", absZ, absInfo{Min: 10, Max: 0}, 5, func(s State) float64 { return s.LT }, 0},
+	} {
+		var e Evdev
+		e.abs[tt.code] = tt.abs
+		e.set(uint16(tt.code), tt.v)
+		if got := tt.get(e.state); math.Abs(got-tt.want) > tolerance {
+			t.Errorf("%s: %d in %d to %d = %v, want %v", tt.name, tt.v, tt.abs.Min, tt.abs.Max, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+//go:build !linux
+
+package gamepad
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var errNoEvdev = errors.New("gamepad: evdev is only available on Linux")
+
+// Device is an input device found by Devices.
+type Device struct {
+	Name            string
+	Vendor, Product uint16
+	Path            string
+}
+
+func (d Device) String() string {
+	return fmt.Sprintf("%s %04x:%04x %q", d.Path, d.Vendor, d.Product, d.Name)
+}
+
+// Devices lists the joysticks and pads known to the kernel.
+func Devices() ([]Device, error) {
+	return nil, errNoEvdev
+}
+
+// FindDevice returns the first pad matching match.
+func FindDevice(match string) (Device, error) {
+	return Device{}, errNoEvdev
+}
+
+// Evdev is a pad read through the Linux event interface.
+type Evdev struct{}
+
+// OpenEvdev fails on this platform.
+func OpenEvdev(path string, timeout time.Duration) (*Evdev, error) {
+	return nil, errNoEvdev
+}
+
+func (e *Evdev) Read() (State, error) {
+	return State{}, ErrDisconnected
+}
+
+func (e *Evdev) Close() error {
+	return nil
+}
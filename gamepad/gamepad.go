@@ -0,0 +1,196 @@
+// Package gamepad reads game controllers as a device independent State.
+//
+// An InputSource produces States; Xbox360 reads a wired Xbox 360 pad over USB
+// and Chan replays synthetic input.
+package gamepad
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ErrDisconnected is returned by Read once the device is gone.
+var ErrDisconnected = errors.New("gamepad: disconnected")
+
+// Button is a set of buttons, one bit each.
+type Button uint16
+
+const (
+	Up Button = 1 << iota // d-pad
+	Down
+	Left
+	Right
+	Start
+	Back
+	LeftThumb // stick clicks
+	RightThumb
+	LB // bumpers
+	RB
+	Guide
+	A
+	B
+	X
+	Y
+)
+
+var buttonNames = []string{
+	"UP", "DOWN", "LEFT", "RIGHT", "START", "BACK", "THUMB L", "THUMB R",
+	"LB", "RB", "GUIDE", "A", "B", "X", "Y",
+}
+
+// Buttons lists every button in order.
+var Buttons = []Button{
+	Up, Down, Left, Right, Start, Back, LeftThumb, RightThumb,
+	LB, RB, Guide, A, B, X, Y,
+}
+
+func (b Button) String() string {
+	var s []string
+	for i, name := range buttonNames {
+		if b&(1<<uint(i)) != 0 {
+			s = append(s, name)
+		}
+	}
+	if len(s) == 0 {
+		return "none"
+	}
+	return strings.Join(s, "+")
+}
+
+// ParseButton returns the button with the given name, e.g. "start" or
+// "thumb-l". Case is ignored.
+func ParseButton(name string) (Button, error) {
+	n := strings.Replace(name, "-", " ", -1)
+	for i, bn := range buttonNames {
+		if strings.EqualFold(n, bn) {
+			return Button(1 << uint(i)), nil
+		}
+	}
+	return 0, fmt.Errorf("gamepad: unknown button %q", name)
+}
+
+// Axis is an analog input.
+type Axis int
+
+const (
+	LeftX Axis = iota
+	LeftY
+	RightX
+	RightY
+	LeftTrigger
+	RightTrigger
+)
+
+var axisNames = []string{"left-x", "left-y", "right-x", "right-y", "left-trigger", "right-trigger"}
+
+func (a Axis) String() string {
+	if a < 0 || int(a) >= len(axisNames) {
+		return fmt.Sprintf("Axis(%d)", int(a))
+	}
+	return axisNames[a]
+}
+
+// ParseAxis returns the axis with the given name, e.g. "left-x".
+func ParseAxis(name string) (Axis, error) {
+	for i, n := range axisNames {
+		if n == name {
+			return Axis(i), nil
+		}
+	}
+	return 0, fmt.Errorf("gamepad: unknown axis %q", name)
+}
+
+// State is a snapshot of a pad. Sticks run from -1 to 1, right and up
+// positive, and triggers from 0 to 1.
+type State struct {
+	Buttons Button
+
+	LX, LY float64
+	RX, RY float64
+	LT, RT float64
+}
+
+// Pressed reports whether all of b are held.
+func (s State) Pressed(b Button) bool {
+	return s.Buttons&b == b
+}
+
+// Changed returns the buttons pressed and released since prev.
+func (s State) Changed(prev State) (pressed, released Button) {
+	d := s.Buttons ^ prev.Buttons
+	return d & s.Buttons, d & prev.Buttons
+}
+
+// Axis returns the value of a.
+func (s State) Axis(a Axis) float64 {
+	switch a {
+	case LeftX:
+		return s.LX
+	case LeftY:
+		return s.LY
+	case RightX:
+		return s.RX
+	case RightY:
+		return s.RY
+	case LeftTrigger:
+		return s.LT
+	case RightTrigger:
+		return s.RT
+	}
+	return 0
+}
+
+// InputSource is a pad.
+type InputSource interface {
+	// Read waits for the next report and returns the state of the pad. A
+	// source may return the unchanged state when idle so callers keep
+	// running, and returns ErrDisconnected once the device is gone.
+	Read() (State, error)
+	Close() error
+}
+
+// Lights is implemented by pads with an LED ring.
+type Lights interface {
+	LED(LED) error
+}
+
+// Rumbler is implemented by pads with rumble motors.
+type Rumbler interface {
+	Rumble(strong, weak float64) error
+}
+
+// Chan is an InputSource of synthetic states sent on C. Closing C or calling
+// Close disconnects it, ending a blocked Read.
+type Chan struct {
+	C chan State
+
+	done chan struct{}
+	once sync.Once
+}
+
+// NewChan returns a Chan with an unbuffered C.
+func NewChan() *Chan {
+	return &Chan{
+		C:    make(chan State),
+		done: make(chan struct{}),
+	}
+}
+
+func (c *Chan) Read() (State, error) {
+	select {
+	case s, ok := <-c.C:
+		if !ok {
+			return State{}, ErrDisconnected
+		}
+		return s, nil
+	case <-c.done:
+		return State{}, ErrDisconnected
+	}
+}
+
+func (c *Chan) Close() error {
+	c.once.Do(func() { close(c.done) })
+	return nil
+}
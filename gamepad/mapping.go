@@ -0,0 +1,161 @@
+package gamepad
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Mode selects what the sticks command.
+type Mode int
+
+const (
+	Absolute Mode = iota // stick deflection is the position
+	Velocity             // stick deflection is the speed, jogging the position
+)
+
+var modeNames = []string{"absolute", "velocity"}
+
+func (m Mode) String() string {
+	if m < 0 || int(m) >= len(modeNames) {
+		return fmt.Sprintf("Mode(%d)", int(m))
+	}
+	return modeNames[m]
+}
+
+// ParseMode returns the mode with the given name.
+func ParseMode(name string) (Mode, error) {
+	for i, n := range modeNames {
+		if n == name {
+			return Mode(i), nil
+		}
+	}
+	return 0, fmt.Errorf("gamepad: unknown mode %q", name)
+}
+
+// Curve shapes one axis.
+type Curve struct {
+	Deadzone float64 // fraction of travel about the centre read as 0
+	Expo     float64 // 0 for a linear response up to 1 for cubic
+	Invert   bool
+	Scale    float64 // output at full deflection
+}
+
+// Apply returns the output for v, which runs from -1 to 1. The response is
+// rescaled to start from 0 at the edge of the deadzone.
+func (c Curve) Apply(v float64) float64 {
+	a := math.Abs(v)
+	if a <= c.Deadzone {
+		return 0
+	}
+	a = math.Min((a-c.Deadzone)/(1-c.Deadzone), 1)
+	a = (1-c.Expo)*a + c.Expo*a*a*a
+	if v < 0 != c.Invert {
+		a = -a
+	}
+	return a * c.Scale
+}
+
+// Mapping turns pad states into x, y and z outputs. The outputs are offset
+// from an origin in Absolute mode and move it in Velocity mode.
+type Mapping struct {
+	Axes   [3]Axis
+	Curves [3]Curve
+	Radial float64 // deadzone of each stick's combined deflection
+	Mode   Mode
+	Limit  float64 // velocity mode bound on each axis, 0 for none
+
+	mu    sync.Mutex
+	pos   [3]float64 // origin
+	last  [3]float64 // last position stepped to
+	speed float64    // 0 for full speed
+}
+
+// Map returns the shaped outputs for s: a position in Absolute mode or a
+// velocity in Velocity mode.
+func (m *Mapping) Map(s State) [3]float64 {
+	s.LX, s.LY = radial(s.LX, s.LY, m.Radial)
+	s.RX, s.RY = radial(s.RX, s.RY, m.Radial)
+	var out [3]float64
+	for i := range out {
+		out[i] = m.Curves[i].Apply(s.Axis(m.Axes[i]))
+	}
+	return out
+}
+
+// radial zeroes a stick inside the deadzone dz and rescales it outside,
+// keeping its direction.
+func radial(x, y, dz float64) (float64, float64) {
+	if dz <= 0 {
+		return x, y
+	}
+	r := math.Hypot(x, y)
+	if r <= dz {
+		return 0, 0
+	}
+	k := (math.Min(r, 1) - dz) / (1 - dz) / r
+	return x * k, y * k
+}
+
+// Step returns the position to command for output out of Map, dt after the
+// last step. In Velocity mode the position moves by out for dt and is held
+// within Limit.
+func (m *Mapping) Step(out [3]float64, dt time.Duration) [3]float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k := m.speed
+	if k == 0 {
+		k = 1
+	}
+	for i, v := range out {
+		if m.Mode != Velocity {
+			m.last[i] = m.pos[i] + v*k
+			continue
+		}
+		p := m.pos[i] + v*k*dt.Seconds()
+		if m.Limit > 0 {
+			p = clamp(p, -m.Limit, m.Limit)
+		}
+		m.pos[i], m.last[i] = p, p
+	}
+	return m.last
+}
+
+// SetSpeed scales the outputs by k, from 0 to 1.
+func (m *Mapping) SetSpeed(k float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.speed = k
+}
+
+// Position returns the position from the last Step.
+func (m *Mapping) Position() [3]float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.last
+}
+
+// Origin returns the origin outputs are offset from.
+func (m *Mapping) Origin() [3]float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.pos
+}
+
+// MoveTo moves the origin to p.
+func (m *Mapping) MoveTo(p [3]float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pos = p
+}
+
+func clamp(x, lo, hi float64) float64 {
+	if x < lo {
+		return lo
+	}
+	if x > hi {
+		return hi
+	}
+	return x
+}
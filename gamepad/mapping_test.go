@@ -0,0 +1,192 @@
+package gamepad
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func near(a, b [3]float64) bool {
+	const tolerance = 1e-12
+	for i := range a {
+		if math.Abs(a[i]-b[i]) > tolerance {
+			return false
+		}
+	}
+	return true
+}
+
+func TestCurve(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		c    Curve
+		in   float64
+		want float64
+	}{
+		{"linear", Curve{Scale: 1}, 0.5, 0.5},
+		{"linear centre", Curve{Scale: 1}, 0, 0},
+		{"linear full", Curve{Scale: 1}, 1, 1},
+		{"linear negative", Curve{Scale: 1}, -0.25, -0.25},
+		{"past full", Curve{Scale: 1}, 1.5, 1},
+		{"past negative full", Curve{Scale: 1}, -1.5, -1},
+
+		{"at deadzone", Curve{Deadzone: 0.1, Scale: 1}, 0.1, 0},
+		{"at negative deadzone", Curve{Deadzone: 0.1, Scale: 1}, -0.1, 0},
+		{"inside deadzone", Curve{Deadzone: 0.1, Scale: 1}, 0.05, 0},
+		{"past deadzone", Curve{Deadzone: 0.1, Scale: 1}, 0.55, 0.5},
+		{"negative past deadzone", Curve{Deadzone: 0.1, Scale: 1}, -0.55, -0.5},
+		{"deadzone full", Curve{Deadzone: 0.1, Scale: 1}, 1, 1},
+		{"deadzone negative full", Curve{Deadzone: 0.1, Scale: 1}, -1, -1},
+
+		{"cubic", Curve{Expo: 1, Scale: 1}, 0.5, 0.125},
+		{"cubic negative", Curve{Expo: 1, Scale: 1}, -0.5, -0.125},
+		{"cubic full", Curve{Expo: 1, Scale: 1}, 1, 1},
+		{"half expo", Curve{Expo: 0.5, Scale: 1}, 0.5, 0.3125},
+		{"half expo full", Curve{Expo: 0.5, Scale: 1}, -1, -1},
+		{"expo past deadzone", Curve{Deadzone: 0.2, Expo: 1, Scale: 1}, 0.6, 0.125},
+		{"expo at deadzone", Curve{Deadzone: 0.2, Expo: 1, Scale: 1}, 0.2, 0},
+
+		{"invert", Curve{Invert: true, Scale: 1}, 0.5, -0.5},
+		{"invert negative full", Curve{Invert: true, Scale: 1}, -1, 1},
+		{"scale", Curve{Scale: 0.02}, 1, 0.02},
+		{"scale negative", Curve{Scale: 0.02}, -0.5, -0.01},
+		{"no scale", Curve{}, 1, 0},
+	} {
+		if got := tt.c.Apply(tt.in); math.Abs(got-tt.want) > 1e-12 {
+			t.Errorf("%s: Apply(%v) = %v, want %v", tt.name, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRadial(t *testing.T) {
+	for _, tt := range []struct {
+		name         string
+		dz           float64
+		x, y         float64
+		wantX, wantY float64
+	}{
+		{"centre", 0.2, 0, 0, 0, 0},
+		{"at deadzone", 0.2, 0.2, 0, 0, 0},
+		{"at negative deadzone", 0.2, 0, -0.2, 0, 0},
+		{"inside deadzone", 0.2, 0.1, -0.1, 0, 0},
+		// Each axis is inside but the stick is not
+		{"diagonal", 0.25, 0.18, 0.24, 0.04, 0.16 / 3},
+		{"past deadzone", 0.2, 0.6, 0, 0.5, 0},
+		{"keeps direction", 0.2, 0.36, 0.48, 0.3, 0.4},
+		{"negative", 0.2, -0.36, -0.48, -0.3, -0.4},
+		{"full", 0.2, 0.6, -0.8, 0.6, -0.8},
+		{"past full", 0.2, 1, 1, 1 / math.Sqrt2, 1 / math.Sqrt2},
+		{"no deadzone", 0, 0.1, -0.05, 0.1, -0.05},
+		{"no deadzone past full", 0, 1, 1, 1, 1},
+	} {
+		x, y := radial(tt.x, tt.y, tt.dz)
+		if math.Abs(x-tt.wantX) > 1e-12 || math.Abs(y-tt.wantY) > 1e-12 {
+			t.Errorf("%s: radial(%v, %v) = %v, %v, want %v, %v", tt.name, tt.x, tt.y, x, y, tt.wantX, tt.wantY)
+		}
+	}
+}
+
+func TestMap(t *testing.T) {
+	lin := Curve{Scale: 1}
+	m := &Mapping{
+		Axes:   [3]Axis{LeftX, LeftY, RightTrigger},
+		Curves: [3]Curve{lin, {Scale: 1, Invert: true}, lin},
+		Radial: 0.2,
+	}
+	for _, tt := range []struct {
+		s    State
+		want [3]float64
+	}{
+		{State{}, [3]float64{}},
+		{State{LX: 0.1, LY: 0.1, RT: 0.1}, [3]float64{0, 0, 0.1}}, // no radial deadzone on triggers
+		{State{LX: 0.36, LY: 0.48, RX: 1}, [3]float64{0.3, -0.4, 0}},
+		{State{LX: -1, RT: 1}, [3]float64{-1, 0, 1}},
+	} {
+		if got := m.Map(tt.s); !near(got, tt.want) {
+			t.Errorf("Map(%+v) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestStepAbsolute(t *testing.T) {
+	var m Mapping
+	m.MoveTo([3]float64{1, 2, 3})
+	for _, tt := range []struct {
+		speed float64
+		out   [3]float64
+		want  [3]float64
+	}{
+		{0, [3]float64{0.1, 0, -0.1}, [3]float64{1.1, 2, 2.9}},
+		{0.25, [3]float64{0.1, 0, -0.1}, [3]float64{1.025, 2, 2.975}},
+		{0.5, [3]float64{0.1, 0, -0.1}, [3]float64{1.05, 2, 2.95}},
+		{0.75, [3]float64{0.1, 0, -0.1}, [3]float64{1.075, 2, 2.925}},
+		{1, [3]float64{0.1, 0, -0.1}, [3]float64{1.1, 2, 2.9}},
+		{1, [3]float64{}, [3]float64{1, 2, 3}},
+	} {
+		m.SetSpeed(tt.speed)
+		// dt does not matter to a position
+		for _, dt := range []time.Duration{0, time.Second} {
+			if got := m.Step(tt.out, dt); !near(got, tt.want) {
+				t.Errorf("speed %v: Step(%v, %v) = %v, want %v", tt.speed, tt.out, dt, got, tt.want)
+			}
+		}
+		if got := m.Position(); !near(got, tt.want) {
+			t.Errorf("speed %v: Position = %v, want %v", tt.speed, got, tt.want)
+		}
+		if got := m.Origin(); got != [3]float64{1, 2, 3} {
+			t.Errorf("speed %v: Origin = %v, moved", tt.speed, got)
+		}
+	}
+}
+
+func TestStepVelocity(t *testing.T) {
+	m := &Mapping{Mode: Velocity, Limit: 0.05}
+	for i, tt := range []struct {
+		speed float64
+		out   [3]float64
+		dt    time.Duration
+		want  [3]float64
+	}{
+		{0, [3]float64{0.1, 0, -0.1}, 100 * time.Millisecond, [3]float64{0.01, 0, -0.01}},
+		{0, [3]float64{0.1, 0, -0.1}, 100 * time.Millisecond, [3]float64{0.02, 0, -0.02}},
+		{0.5, [3]float64{0.1, 0, -0.1}, 100 * time.Millisecond, [3]float64{0.025, 0, -0.025}},
+		{0.25, [3]float64{0.1, 0, -0.1}, 100 * time.Millisecond, [3]float64{0.0275, 0, -0.0275}},
+		{0.75, [3]float64{0, 0.1, 0}, 100 * time.Millisecond, [3]float64{0.0275, 0.0075, -0.0275}},
+		{1, [3]float64{0, 0, 0}, time.Second, [3]float64{0.0275, 0.0075, -0.0275}},
+		{1, [3]float64{0.1, 0, 0}, 0, [3]float64{0.0275, 0.0075, -0.0275}},
+		// Held at the limit, and back from it at once
+		{1, [3]float64{1, 1, -1}, time.Second, [3]float64{0.05, 0.05, -0.05}},
+		{1, [3]float64{1, 1, -1}, time.Second, [3]float64{0.05, 0.05, -0.05}},
+		{1, [3]float64{-0.1, 0, 0.1}, 100 * time.Millisecond, [3]float64{0.04, 0.05, -0.04}},
+		{1, [3]float64{-1, -1, 1}, 10 * time.Second, [3]float64{-0.05, -0.05, 0.05}},
+	} {
+		m.SetSpeed(tt.speed)
+		got := m.Step(tt.out, tt.dt)
+		if !near(got, tt.want) {
+			t.Errorf("step %d: Step(%v, %v) at speed %v = %v, want %v", i, tt.out, tt.dt, tt.speed, got, tt.want)
+		}
+		if m.Position() != got || m.Origin() != got {
+			t.Errorf("step %d: Position %v, Origin %v, want both %v", i, m.Position(), m.Origin(), got)
+		}
+	}
+
+	// No limit
+	m = &Mapping{Mode: Velocity}
+	if got := m.Step([3]float64{1, -1, 0}, 10*time.Second); !near(got, [3]float64{10, -10, 0}) {
+		t.Errorf("unlimited Step = %v, want [10 -10 0]", got)
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	for _, m := range []Mode{Absolute, Velocity} {
+		if got, err := ParseMode(m.String()); got != m || err != nil {
+			t.Errorf("ParseMode(%q) = %v, %v", m, got, err)
+		}
+	}
+	if _, err := ParseMode("jog"); err == nil {
+		t.Error(`ParseMode("jog") succeeded`)
+	}
+	if s := Mode(5).String(); s != "Mode(5)" {
+		t.Errorf("Mode(5) = %q", s)
+	}
+}
@@ -0,0 +1,203 @@
+package gamepad
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kylelemons/gousb/usb"
+)
+
+// LED is an Xbox 360 ring light pattern.
+type LED byte
+
+const (
+	Empty      LED = iota // 00000000 ( 0) no LEDs
+	WarnAll               // 00000001 ( 1) flash all briefly
+	NewPlayer1            // 00000010 ( 2) p1 flash then solid
+	NewPlayer2            // 00000011
+	NewPlayer3            // 00000100
+	NewPlayer4            // 00000101
+	Player1               // 00000110 ( 6) p1 solid
+	Player2               // 00000111
+	Player3               // 00001000
+	Player4               // 00001001
+	Waiting               // 00001010 (10) empty w/ loops
+	WarnPlayer            // 00001011 (11) flash active
+	_                     // 00001100 (12) empty
+	Battery               // 00001101 (13) squiggle
+	Searching             // 00001110 (14) slow flash
+	Booting               // 00001111 (15) solid then flash
+)
+
+// Xbox360 is a wired Xbox 360 controller read over USB.
+// https://github.com/Grumbel/xboxdrv/blob/master/PROTOCOL
+type Xbox360 struct {
+	ctx *usb.Context
+	dev *usb.Device
+	in  usb.Endpoint
+	out usb.Endpoint
+
+	buf   [512]byte
+	state State
+}
+
+// OpenXbox360 opens the only wired Xbox 360 controller attached. Reads time
+// out after timeout with the state unchanged.
+func OpenXbox360(timeout time.Duration) (*Xbox360, error) {
+	// One context should be opened for the application.
+	x := &Xbox360{ctx: usb.NewContext()}
+
+	// ListDevices is used to find the devices to open.
+	devs, err := x.ctx.ListDevices(func(desc *usb.Descriptor) bool {
+		return desc.Vendor == usb.ID(0x045e) && desc.Product == usb.ID(0x028e)
+	})
+	if err == nil && len(devs) != 1 {
+		err = fmt.Errorf("gamepad: found %d Xbox 360 controllers, want 1", len(devs))
+	}
+	if err != nil {
+		for _, d := range devs {
+			d.Close()
+		}
+		x.ctx.Close()
+		return nil, err
+	}
+	x.dev = devs[0]
+
+	if err := x.open(timeout); err != nil {
+		x.Close()
+		return nil, err
+	}
+	return x, nil
+}
+
+func (x *Xbox360) open(timeout time.Duration) error {
+	if err := x.dev.Reset(); err != nil {
+		return err
+	}
+	x.dev.ReadTimeout = timeout
+
+	// config = 1, iface = 0, setup = 0, endIn = 1, endOut = 1
+	var err error
+	x.in, err = x.dev.OpenEndpoint(01, 00, 00, 01|uint8(usb.ENDPOINT_DIR_IN))
+	if err != nil {
+		return err
+	}
+	x.out, err = x.dev.OpenEndpoint(01, 00, 00, 01|uint8(usb.ENDPOINT_DIR_OUT))
+	return err
+}
+
+// Close releases the device.
+func (x *Xbox360) Close() error {
+	err := x.dev.Close()
+	x.ctx.Close()
+	return err
+}
+
+// Read returns the state after the next input report.
+func (x *Xbox360) Read() (State, error) {
+	n, err := x.in.Read(x.buf[:])
+	switch err {
+	case usb.ERROR_NO_DEVICE, usb.LIBUSB_TRANSFER_NO_DEVICE:
+		return x.state, ErrDisconnected
+	case usb.ERROR_TIMEOUT, usb.LIBUSB_TRANSFER_TIMED_OUT:
+		return x.state, nil // idle
+	}
+	if err != nil {
+		return x.state, fmt.Errorf("gamepad: read: %v", err)
+	}
+	if n != 20 || x.buf[0] != 0x00 {
+		return x.state, nil // LED and headset reports
+	}
+	x.state = decode360(x.buf[:n])
+	return x.state, nil
+}
+
+// xbox360Buttons are the bits of bytes 2 and 3 of an input report.
+var xbox360Buttons = []struct {
+	idx int
+	bit uint
+	b   Button
+}{
+	{2, 0, Up},
+	{2, 1, Down},
+	{2, 2, Left},
+	{2, 3, Right},
+	{2, 4, Start},
+	{2, 5, Back},
+	{2, 6, LeftThumb},
+	{2, 7, RightThumb},
+	{3, 0, LB},
+	{3, 1, RB},
+	{3, 2, Guide},
+	{3, 4, A},
+	{3, 5, B},
+	{3, 6, X},
+	{3, 7, Y},
+}
+
+// decode360 decodes a 20 byte input report.
+func decode360(r []byte) State {
+	var s State
+	for _, v := range xbox360Buttons {
+		if r[v.idx]&(1<<v.bit) != 0 {
+			s.Buttons |= v.b
+		}
+	}
+
+	// 8-bit triggers
+	s.LT = float64(r[4]) / 255
+	s.RT = float64(r[5]) / 255
+
+	// 16-bit sticks, little endian
+	stick := func(lo, hi byte) float64 {
+		return float64(int16(hi)<<8|int16(lo)) / 32768
+	}
+	s.LX = stick(r[6], r[7])
+	s.LY = stick(r[8], r[9])
+	s.RX = stick(r[10], r[11])
+	s.RY = stick(r[12], r[13])
+	return s
+}
+
+// LED sets the ring light.
+func (x *Xbox360) LED(l LED) error {
+	_, err := x.out.Write([]byte{0x01, 0x03, byte(l)})
+	return err
+}
+
+// Rumble runs the large, low frequency motor at strong and the small one at
+// weak, from 0 to 1, until changed.
+func (x *Xbox360) Rumble(strong, weak float64) error {
+	level := func(v float64) byte {
+		return byte(clamp(v, 0, 1) * 255)
+	}
+	_, err := x.out.Write([]byte{0x00, 0x08, 0x00, level(strong), level(weak), 0x00, 0x00, 0x00})
+	return err
+}
+
+// SetPlayer spins the ring light and settles on player.
+func (x *Xbox360) SetPlayer(player LED) {
+	spin := []LED{
+		Player1, Player2, Player4, Player3,
+	}
+	spinIdx := 0
+	spinDelay := 100 * time.Millisecond
+
+	x.LED(Booting)
+	time.Sleep(100 * time.Millisecond)
+	for spinDelay > 20*time.Millisecond {
+		x.LED(spin[spinIdx])
+		time.Sleep(spinDelay)
+		spinIdx = (spinIdx + 1) % len(spin)
+		spinDelay -= 5 * time.Millisecond
+	}
+	for i := 0; i < 40; i++ { // just for safety
+		cur := spin[spinIdx]
+		x.LED(cur)
+		time.Sleep(spinDelay)
+		spinIdx = (spinIdx + 1) % len(spin)
+		if cur == player {
+			break
+		}
+	}
+}
@@ -0,0 +1,367 @@
+// Package gcode interprets G-code toolpaths as arm moves.
+//
+// Supported: G0/G1 linear moves, G2/G3 arcs in the XY plane (I/J or R),
+// G4 dwell (P seconds), G17, G20/G21 units, G90/G91 distance mode, G94 and
+// F feed rates. M, S and T words are ignored.
+package gcode
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/afking/godelta/trajectory"
+)
+
+// Kind of move.
+type Kind int
+
+const (
+	Rapid Kind = iota // G0
+	Feed              // G1, G2, G3
+	Dwell             // G4
+)
+
+// Move is a single block, flattened to a path in the POINT frame.
+type Move struct {
+	Kind  Kind
+	Path  []trajectory.Point // starting at the previous position
+	Feed  float64            // m/s, for Feed moves
+	Dwell time.Duration
+	Line  int
+}
+
+// Error reports the line a program failed on.
+type Error struct {
+	Line int
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("gcode: line %d: %s", e.Line, e.Msg)
+}
+
+const (
+	mm   = 0.001
+	inch = 0.0254
+)
+
+// Interpreter holds the modal state of a program.
+type Interpreter struct {
+	// Origin is the POINT frame position of program zero, where the tool
+	// starts.
+	Origin trajectory.Point
+
+	// Tolerance is the maximum distance between an arc and its chords in
+	// metres, zero means 0.01 mm.
+	Tolerance float64
+
+	pos      trajectory.Point // program coordinates, metres
+	unit     float64
+	relative bool
+	motion   int
+	feed     float64 // m/s
+	line     int
+}
+
+// Parse interprets a program with a fresh Interpreter.
+func Parse(r io.Reader) ([]Move, error) {
+	return (&Interpreter{}).Parse(r)
+}
+
+type word struct {
+	letter byte
+	value  float64
+}
+
+// Parse interprets a program from its start state.
+func (in *Interpreter) Parse(r io.Reader) ([]Move, error) {
+	in.pos = trajectory.Point{}
+	in.unit = mm
+	in.relative = false
+	in.motion = -1
+	in.feed = 0
+	in.line = 0
+
+	var moves []Move
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		in.line++
+		words, err := in.words(sc.Text())
+		if err != nil {
+			return nil, err
+		}
+		m, err := in.block(words)
+		if err != nil {
+			return nil, err
+		}
+		moves = append(moves, m...)
+	}
+	return moves, sc.Err()
+}
+
+func (in *Interpreter) errorf(format string, a ...interface{}) error {
+	return &Error{Line: in.line, Msg: fmt.Sprintf(format, a...)}
+}
+
+// words splits a line into words, dropping comments.
+func (in *Interpreter) words(line string) ([]word, error) {
+	if i := strings.IndexByte(line, ';'); i >= 0 {
+		line = line[:i]
+	}
+	for {
+		i := strings.IndexByte(line, '(')
+		if i < 0 {
+			break
+		}
+		j := strings.IndexByte(line[i:], ')')
+		if j < 0 {
+			return nil, in.errorf("unterminated comment")
+		}
+		line = line[:i] + " " + line[i+j+1:]
+	}
+	line = strings.TrimSpace(line)
+	if line == "%" {
+		return nil, nil
+	}
+
+	var words []word
+	for len(line) > 0 {
+		c := line[0]
+		if c == ' ' || c == '\t' {
+			line = line[1:]
+			continue
+		}
+		if c >= 'a' && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		if c < 'A' || c > 'Z' {
+			return nil, in.errorf("unexpected %q", line[0])
+		}
+		n := 1
+		for n < len(line) && strings.IndexByte("+-.0123456789 ", line[n]) >= 0 {
+			n++
+		}
+		v, err := strconv.ParseFloat(strings.Replace(line[1:n], " ", "", -1), 64)
+		if err != nil {
+			return nil, in.errorf("bad number for %c", c)
+		}
+		words = append(words, word{c, v})
+		line = line[n:]
+	}
+	return words, nil
+}
+
+// block executes one line.
+func (in *Interpreter) block(words []word) ([]Move, error) {
+	var (
+		axes   = map[byte]float64{}
+		motion = -1
+		dwell  = false
+	)
+	// Modal G words apply to the whole block, wherever they are on the line
+	for _, w := range words {
+		if w.letter != 'G' {
+			continue
+		}
+		switch w.value {
+		case 0, 1, 2, 3:
+			motion = int(w.value)
+		case 4:
+			dwell = true
+		case 17, 94:
+		case 20:
+			in.unit = inch
+		case 21:
+			in.unit = mm
+		case 90:
+			in.relative = false
+		case 91:
+			in.relative = true
+		default:
+			return nil, in.errorf("unsupported G%v", w.value)
+		}
+	}
+	for _, w := range words {
+		switch w.letter {
+		case 'G':
+		case 'F':
+			if w.value <= 0 {
+				return nil, in.errorf("feed rate must be positive")
+			}
+			in.feed = w.value * in.unit / 60
+		case 'X', 'Y', 'Z', 'I', 'J', 'R', 'P':
+			axes[w.letter] = w.value
+		case 'M', 'N', 'S', 'T':
+		default:
+			return nil, in.errorf("unsupported word %c", w.letter)
+		}
+	}
+
+	if dwell {
+		p, ok := axes['P']
+		if !ok || p < 0 {
+			return nil, in.errorf("G4 needs a P dwell time")
+		}
+		return []Move{{
+			Kind:  Dwell,
+			Path:  []trajectory.Point{in.point(in.pos)},
+			Dwell: time.Duration(p * float64(time.Second)),
+			Line:  in.line,
+		}}, nil
+	}
+
+	if motion >= 0 {
+		in.motion = motion
+	}
+	_, x := axes['X']
+	_, y := axes['Y']
+	_, z := axes['Z']
+	if !x && !y && !z {
+		return nil, nil
+	}
+	if in.motion < 0 {
+		return nil, in.errorf("no motion mode")
+	}
+
+	end := in.pos
+	for _, a := range []struct {
+		letter byte
+		v      *float64
+	}{
+		{'X', &end.X},
+		{'Y', &end.Y},
+		{'Z', &end.Z},
+	} {
+		v, ok := axes[a.letter]
+		if !ok {
+			continue
+		}
+		if in.relative {
+			*a.v += v * in.unit
+		} else {
+			*a.v = v * in.unit
+		}
+	}
+
+	m := Move{
+		Kind: Feed,
+		Feed: in.feed,
+		Line: in.line,
+	}
+	switch in.motion {
+	case 0:
+		m.Kind, m.Feed = Rapid, 0
+		m.Path = []trajectory.Point{in.point(in.pos), in.point(end)}
+	case 1:
+		m.Path = []trajectory.Point{in.point(in.pos), in.point(end)}
+	case 2, 3:
+		path, err := in.arc(end, axes, in.motion == 2)
+		if err != nil {
+			return nil, err
+		}
+		m.Path = path
+	}
+	if m.Kind == Feed && in.feed == 0 {
+		return nil, in.errorf("no feed rate")
+	}
+	in.pos = end
+	return []Move{m}, nil
+}
+
+// point converts program coordinates to the POINT frame.
+func (in *Interpreter) point(p trajectory.Point) trajectory.Point {
+	return trajectory.Point{
+		X: p.X + in.Origin.X,
+		Y: p.Y + in.Origin.Y,
+		Z: p.Z + in.Origin.Z,
+	}
+}
+
+// The end of an I/J arc may be off the circle through its start by rounding
+// in the program, up to arcTolerance or arcRelTolerance of the radius,
+// whichever is more.
+const (
+	arcTolerance    = 0.002 * mm
+	arcRelTolerance = 0.001
+)
+
+// arc flattens a helical arc from the current position to end.
+func (in *Interpreter) arc(end trajectory.Point, axes map[byte]float64, cw bool) ([]trajectory.Point, error) {
+	start := in.pos
+	var cx, cy float64
+
+	if r, ok := axes['R']; ok {
+		dx, dy := end.X-start.X, end.Y-start.Y
+		d := math.Hypot(dx, dy)
+		r *= in.unit
+		if d == 0 || d > 2*math.Abs(r)+1e-9 {
+			return nil, in.errorf("invalid arc radius")
+		}
+		h := math.Sqrt(math.Max(0, r*r-d*d/4))
+		// Centre left of the chord for short CCW arcs.
+		if cw != (r < 0) {
+			h = -h
+		}
+		cx = start.X + dx/2 - dy/d*h
+		cy = start.Y + dy/2 + dx/d*h
+	} else {
+		i, iok := axes['I']
+		j, jok := axes['J']
+		if !iok && !jok {
+			return nil, in.errorf("arc needs I/J or R")
+		}
+		cx, cy = start.X+i*in.unit, start.Y+j*in.unit
+	}
+
+	r := math.Hypot(start.X-cx, start.Y-cy)
+	if r == 0 {
+		return nil, in.errorf("zero arc radius")
+	}
+	if d := math.Abs(math.Hypot(end.X-cx, end.Y-cy) - r); d > math.Max(arcTolerance, arcRelTolerance*r) {
+		return nil, in.errorf("arc end is %.4g mm off the circle", d/mm)
+	}
+	a0 := math.Atan2(start.Y-cy, start.X-cx)
+	a1 := math.Atan2(end.Y-cy, end.X-cx)
+	sweep := a1 - a0
+	if cw {
+		sweep = -sweep
+	}
+	for sweep <= 1e-9 {
+		sweep += 2 * math.Pi // equal ends make a full circle
+	}
+
+	tol := in.Tolerance
+	if tol == 0 {
+		tol = 0.01 * mm
+	}
+	// Keep chords within tolerance and vertices well under the planner's
+	// corner limit so it blends through them.
+	step := trajectory.MaxCorner / 2
+	if tol < r {
+		step = math.Min(step, 2*math.Acos(1-tol/r))
+	}
+	n := int(math.Ceil(sweep / step))
+	if n < 1 {
+		n = 1
+	}
+	if cw {
+		sweep = -sweep
+	}
+
+	path := []trajectory.Point{in.point(start)}
+	for k := 1; k < n; k++ {
+		f := float64(k) / float64(n)
+		a := a0 + sweep*f
+		path = append(path, in.point(trajectory.Point{
+			X: cx + r*math.Cos(a),
+			Y: cy + r*math.Sin(a),
+			Z: start.Z + (end.Z-start.Z)*f,
+		}))
+	}
+	return append(path, in.point(end)), nil
+}
@@ -0,0 +1,222 @@
+package gcode
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/afking/godelta/trajectory"
+)
+
+func pt(x, y, z float64) trajectory.Point {
+	return trajectory.Point{X: x * mm, Y: y * mm, Z: z * mm}
+}
+
+func near(p, q trajectory.Point) bool {
+	const tolerance = 1e-12 // metres
+	return math.Abs(p.X-q.X) < tolerance && math.Abs(p.Y-q.Y) < tolerance && math.Abs(p.Z-q.Z) < tolerance
+}
+
+// parseLast parses prog and returns its last move.
+func parseLast(t *testing.T, prog string) Move {
+	t.Helper()
+	moves, err := Parse(strings.NewReader(prog))
+	if err != nil {
+		t.Fatalf("%q: %v", prog, err)
+	}
+	if len(moves) == 0 {
+		t.Fatalf("%q: no moves", prog)
+	}
+	return moves[len(moves)-1]
+}
+
+func TestLinear(t *testing.T) {
+	for _, tt := range []struct {
+		prog  string
+		kind  Kind
+		start trajectory.Point
+		end   trajectory.Point
+		feed  float64 // m/s
+	}{
+		{"G0 X10 Y20 Z-5", Rapid, pt(0, 0, 0), pt(10, 20, -5), 0},
+		{"G1 X10 F600", Feed, pt(0, 0, 0), pt(10, 0, 0), 0.01},
+		{"g1 x10 f600", Feed, pt(0, 0, 0), pt(10, 0, 0), 0.01},
+		{"G1X10F600", Feed, pt(0, 0, 0), pt(10, 0, 0), 0.01},
+		{"G1 X - 1 0 F600", Feed, pt(0, 0, 0), pt(-10, 0, 0), 0.01},
+		// Modal motion and feed carry over
+		{"G1 X10 F600\nY5", Feed, pt(10, 0, 0), pt(10, 5, 0), 0.01},
+		{"G0 X10\nG1 Y5 F60\nX0", Feed, pt(10, 5, 0), pt(0, 5, 0), 0.001},
+		{"N10 G1 X1 F60 M3 S1000 T1", Feed, pt(0, 0, 0), pt(1, 0, 0), 0.001},
+
+		// Units
+		{"G20 G0 X1", Rapid, pt(0, 0, 0), pt(25.4, 0, 0), 0},
+		{"G20\nG1 X1 F60\nG21 X10", Feed, pt(25.4, 0, 0), pt(10, 0, 0), 0.0254},
+		// G20 applies to F on the same line, wherever it is
+		{"F60 G20 G1 X1", Feed, pt(0, 0, 0), pt(25.4, 0, 0), 0.0254},
+		{"G20 F60 G1 X1", Feed, pt(0, 0, 0), pt(25.4, 0, 0), 0.0254},
+		{"G1 X1 F60 G20", Feed, pt(0, 0, 0), pt(25.4, 0, 0), 0.0254},
+
+		// Distance modes
+		{"G0 X10 Y10\nG91 G0 X5 Y-5", Rapid, pt(10, 10, 0), pt(15, 5, 0), 0},
+		{"G0 X10 Y10\nX5 Y-5 G91", Rapid, pt(10, 10, 0), pt(15, 5, 0), 0},
+		{"G91 G0 X5\nG90 X1", Rapid, pt(5, 0, 0), pt(1, 0, 0), 0},
+		{"G91 G20 G0 X1\nX1", Rapid, pt(25.4, 0, 0), pt(50.8, 0, 0), 0},
+	} {
+		m := parseLast(t, tt.prog)
+		if m.Kind != tt.kind || len(m.Path) != 2 || !near(m.Path[0], tt.start) || !near(m.Path[1], tt.end) {
+			t.Errorf("%q = kind %v path %v, want kind %v from %v to %v", tt.prog, m.Kind, m.Path, tt.kind, tt.start, tt.end)
+		}
+		if math.Abs(m.Feed-tt.feed) > 1e-12 {
+			t.Errorf("%q: feed %v m/s, want %v", tt.prog, m.Feed, tt.feed)
+		}
+	}
+}
+
+func TestArc(t *testing.T) {
+	for _, tt := range []struct {
+		prog   string
+		centre trajectory.Point
+		r      float64 // mm
+		end    trajectory.Point
+		cw     bool
+		sweep  float64 // radians
+	}{
+		{"G1 F60 X10\nG3 X0 Y10 I-10", pt(0, 0, 0), 10, pt(0, 10, 0), false, math.Pi / 2},
+		{"G1 F60 X10\nG2 X0 Y-10 I-10", pt(0, 0, 0), 10, pt(0, -10, 0), true, math.Pi / 2},
+		{"G1 F60 X10\nG2 X0 Y10 I-10", pt(0, 0, 0), 10, pt(0, 10, 0), true, 3 * math.Pi / 2},
+		{"G1 F60 X10\nG3 X10 I-10", pt(0, 0, 0), 10, pt(10, 0, 0), false, 2 * math.Pi},
+		{"G1 F60 X10\nG3 X0 Y10 I-10 Z5", pt(0, 0, 0), 10, pt(0, 10, 5), false, math.Pi / 2}, // helix
+		// R picks the short arc, or the long one when negative
+		{"G1 F60 X10\nG3 X0 Y10 R10", pt(0, 0, 0), 10, pt(0, 10, 0), false, math.Pi / 2},
+		{"G1 F60 X10\nG3 X0 Y10 R-10", pt(10, 10, 0), 10, pt(0, 10, 0), false, 3 * math.Pi / 2},
+		{"G1 F60 X10\nG2 X0 Y10 R10", pt(10, 10, 0), 10, pt(0, 10, 0), true, math.Pi / 2},
+		// Units and relative I/J, X and Y
+		{"G20 G1 F60 X1\nG91 G3 X-1 Y1 I-1", pt(0, 0, 0), 25.4, pt(0, 25.4, 0), false, math.Pi / 2},
+	} {
+		m := parseLast(t, tt.prog)
+		r := tt.r * mm
+		if m.Kind != Feed || len(m.Path) < 3 || !near(m.Path[len(m.Path)-1], tt.end) {
+			t.Errorf("%q = %v to %v, want an arc to %v", tt.prog, m.Kind, m.Path[len(m.Path)-1], tt.end)
+			continue
+		}
+
+		// On the circle, turning the right way and within tolerance
+		sweep := 0.0
+		for i, p := range m.Path {
+			if d := math.Hypot(p.X-tt.centre.X, p.Y-tt.centre.Y); math.Abs(d-r) > 1e-9 {
+				t.Errorf("%q: point %d %v is %v from the centre, want %v", tt.prog, i, p, d, r)
+				break
+			}
+			if i == 0 {
+				continue
+			}
+			q := m.Path[i-1]
+			a := math.Atan2(p.Y-tt.centre.Y, p.X-tt.centre.X) - math.Atan2(q.Y-tt.centre.Y, q.X-tt.centre.X)
+			a = math.Remainder(a, 2*math.Pi)
+			if (a < 0) != tt.cw {
+				t.Errorf("%q: turns %v at point %d, want clockwise %v", tt.prog, a, i, tt.cw)
+				break
+			}
+			if sag := r * (1 - math.Cos(a/2)); sag > 0.01*mm {
+				t.Errorf("%q: chord %d sags %v, want within 0.01 mm", tt.prog, i, sag)
+			}
+			sweep += math.Abs(a)
+		}
+		if math.Abs(sweep-tt.sweep) > 1e-9 {
+			t.Errorf("%q: sweeps %v, want %v", tt.prog, sweep, tt.sweep)
+		}
+	}
+}
+
+func TestDwell(t *testing.T) {
+	moves, err := Parse(strings.NewReader("G0 X1 Y2\nG4 P1.5\nG4 P0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(moves) != 3 {
+		t.Fatalf("%d moves, want 3", len(moves))
+	}
+	for i, want := range []time.Duration{1500 * time.Millisecond, 0} {
+		m := moves[i+1]
+		if m.Kind != Dwell || m.Dwell != want || len(m.Path) != 1 || !near(m.Path[0], pt(1, 2, 0)) {
+			t.Errorf("dwell %d = %+v, want %v at (1, 2, 0) mm", i, m, want)
+		}
+	}
+
+	// Dwells do not change the motion mode
+	m := parseLast(t, "G1 X1 F60\nG4 P1\nX2")
+	if m.Kind != Feed || !near(m.Path[1], pt(2, 0, 0)) {
+		t.Errorf("move after dwell = %+v", m)
+	}
+}
+
+func TestComments(t *testing.T) {
+	moves, err := Parse(strings.NewReader(`%
+(header comment)
+G21 (mm) G90 ; absolute
+; whole line comment
+G0 X1 (to x) Y2 ( two ) ; Z99
+
+%
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(moves) != 1 || !near(moves[0].Path[1], pt(1, 2, 0)) || moves[0].Line != 5 {
+		t.Errorf("moves = %+v, want one rapid to (1, 2, 0) mm on line 5", moves)
+	}
+}
+
+func TestOrigin(t *testing.T) {
+	in := &Interpreter{Origin: trajectory.Point{X: 0.01, Y: -0.01, Z: -0.05}}
+	moves, err := in.Parse(strings.NewReader("G0 X1\nG1 Z1 F60"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !near(moves[0].Path[0], in.Origin) || !near(moves[1].Path[1], trajectory.Point{X: 0.011, Y: -0.01, Z: -0.049}) {
+		t.Errorf("moves = %+v, want offset by the origin", moves)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, tt := range []struct {
+		prog string
+		line int
+		msg  string
+	}{
+		{"G0 X1\n(comment", 2, "unterminated comment"},
+		{"G1 X1", 1, "no feed rate"},
+		{"X1", 1, "no motion mode"},
+		{"G0 X1\nG5 X2", 2, "unsupported G5"},
+		{"G0 #1 X1", 1, "unexpected '#'"},
+		{"G0 X1..2", 1, "bad number for X"},
+		{"G0 Q1", 1, "unsupported word Q"},
+		{"G1 X1 F0", 1, "feed rate must be positive"},
+		{"G4", 1, "G4 needs a P dwell time"},
+		{"G4 P-1", 1, "G4 needs a P dwell time"},
+		{"G1 F60 X10\nG3 X10", 2, "arc needs I/J or R"},
+		{"G1 F60 X10\nG3 X0 I0", 2, "zero arc radius"},
+		{"G1 F60 X10\nG3 X-20 R5", 2, "invalid arc radius"},
+		{"G1 F60 X10\nG3 X10 R5", 2, "invalid arc radius"},
+		// The end is 1 mm off the circle through the start
+		{"G1 F60 X10\nG3 X0 Y11 I-10", 2, "arc end is 1 mm off the circle"},
+	} {
+		_, err := Parse(strings.NewReader(tt.prog))
+		var gerr *Error
+		if !errors.As(err, &gerr) || gerr.Line != tt.line || gerr.Msg != tt.msg {
+			t.Errorf("%q: %v, want line %d: %s", tt.prog, err, tt.line, tt.msg)
+		}
+	}
+
+	// Rounding in the program is allowed for
+	for _, prog := range []string{
+		"G1 F60 X10\nG3 X0 Y10.001 I-10",
+		"G1 F60 X1000\nG3 X0 Y1000.5 I-1000",
+	} {
+		if _, err := Parse(strings.NewReader(prog)); err != nil {
+			t.Errorf("%q: %v", prog, err)
+		}
+	}
+}
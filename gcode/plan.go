@@ -0,0 +1,70 @@
+package gcode
+
+import (
+	"github.com/afking/godelta/trajectory"
+)
+
+// Plan turns moves into a trajectory within l. Rapids run at the limit
+// velocity, feed moves at the lower of their feed rate and the limit.
+// Consecutive feed moves at the same rate are run as one path, stopping at
+// corners sharper than trajectory.MaxCorner.
+func Plan(moves []Move, l trajectory.Limits) (*trajectory.Trajectory, error) {
+	var ts []*trajectory.Trajectory
+	var path []trajectory.Point
+	var feed float64
+
+	flush := func() error {
+		if len(path) == 0 {
+			return nil
+		}
+		fl := l
+		if feed > 0 && feed < fl.Velocity {
+			fl.Velocity = feed
+		}
+		t, err := trajectory.Corners(path, fl, trajectory.MaxCorner)
+		if err != nil {
+			return err
+		}
+		ts = append(ts, t)
+		path = nil
+		return nil
+	}
+
+	for _, m := range moves {
+		switch m.Kind {
+		case Dwell:
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			ts = append(ts, trajectory.Hold(m.Path[0], m.Dwell))
+		case Rapid:
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			t, err := trajectory.New(m.Path, l)
+			if err != nil {
+				return nil, err
+			}
+			ts = append(ts, t)
+		case Feed:
+			if len(path) > 0 && m.Feed != feed {
+				if err := flush(); err != nil {
+					return nil, err
+				}
+			}
+			if len(path) == 0 {
+				path = append(path, m.Path...)
+			} else {
+				path = append(path, m.Path[1:]...)
+			}
+			feed = m.Feed
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	if len(ts) == 0 {
+		return trajectory.Hold(trajectory.Point{}, 0), nil
+	}
+	return trajectory.Join(ts...), nil
+}
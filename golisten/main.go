@@ -2,28 +2,34 @@ package main
 
 import (
 	"bytes"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net"
-	"os"
-	"time"
+
+	"github.com/afking/godelta/config"
 )
 
 const (
-	CONN_HOST string = "192.168.1.100"
-	CONN_PORT string = "2616"
 	CONN_TYPE string = "tcp"
 )
 
 func main() {
-	if l, err := net.Listen(CONN_TYPE, CONN_HOST+":"+CONN_PORT); err != nil {
+	cfg, err := config.Load("")
+	if err != nil {
+		log.Fatal(err)
+	}
+	addr := flag.String("addr", cfg.Listen, "listen address")
+	flag.Parse()
 
+	l, err := net.Listen(CONN_TYPE, *addr)
+	if err != nil {
 		log.Fatal(err)
 	}
 	defer l.Close()
 
-	fmt.Println("Listening on " + CONN_HOST + ":" + CONN_PORT)
+	fmt.Println("Listening on " + *addr)
 	for {
 		// Listen for an incoming connection.
 		conn, err := l.Accept()
@@ -43,6 +49,6 @@ func handleRequest(conn net.Conn) {
 		fmt.Println(err)
 	}
 
-	log.Printf("%n bytes received\n", f)
+	log.Printf("%d bytes received\n", n)
 	conn.Close()
 }
@@ -0,0 +1,170 @@
+// Package kinematics solves delta arm inverse and forward kinematics.
+//
+// Points are in the arm's POINT frame: metres, z up, origin Home metres below
+// the centre of the base. Motor i (numbered from 0 here, 1 on the arm) sits
+// at i*120 degrees from the +x axis. Angles are radians with 0 horizontal and
+// positive rotating the upper arm down.
+package kinematics
+
+import (
+	"errors"
+	"math"
+)
+
+// ErrUnreachable is returned for points or angles outside the arm's reach.
+var ErrUnreachable = errors.New("kinematics: unreachable")
+
+// Geometry describes the arm dimensions in metres.
+type Geometry struct {
+	BaseRadius     float64 // centre of base to motor axis
+	EffectorRadius float64 // centre of effector to lower arm joint
+	UpperArm       float64 // motor axis to elbow
+	LowerArm       float64 // elbow to effector joint
+	Home           float64 // depth of the POINT origin below the base
+}
+
+// Default approximates the lab arm.
+var Default = Geometry{
+	BaseRadius:     0.08,
+	EffectorRadius: 0.03,
+	UpperArm:       0.10,
+	LowerArm:       0.22,
+	Home:           0.20,
+}
+
+// Angles are the three motor angles.
+type Angles [3]float64
+
+var (
+	cos120 = math.Cos(2 * math.Pi / 3)
+	sin120 = math.Sin(2 * math.Pi / 3)
+
+	// Unit vectors from the base centre towards each motor.
+	axes = [3][2]float64{
+		{1, 0},
+		{cos120, sin120},
+		{cos120, -sin120},
+	}
+)
+
+// Inverse returns the motor angles placing the effector at (x, y, z).
+func (g Geometry) Inverse(x, y, z float64) (Angles, error) {
+	var a Angles
+	if !finite(x, y, z) {
+		return a, ErrUnreachable
+	}
+	z -= g.Home
+	for i, u := range axes {
+		// Project into the plane of arm i.
+		r := x*u[0] + y*u[1]  // radial
+		s := -x*u[1] + y*u[0] // out of plane
+		t, err := g.angle(r, s, z)
+		if err != nil {
+			return a, err
+		}
+		a[i] = t
+	}
+	return a, nil
+}
+
+// angle solves a single arm for an effector joint at radial offset r, out of
+// plane offset s and height z, choosing the elbow-out solution.
+func (g Geometry) angle(r, s, z float64) (float64, error) {
+	d := g.BaseRadius - g.EffectorRadius - r
+	rf, re := g.UpperArm, g.LowerArm
+
+	// d cos(t) + z sin(t) = k
+	k := (re*re - s*s - d*d - z*z - rf*rf) / (2 * rf)
+	rho := math.Hypot(d, z)
+	if rho == 0 || math.Abs(k) > rho {
+		return 0, ErrUnreachable
+	}
+	t := math.Atan2(z, d) + math.Acos(k/rho)
+	if !finite(t) {
+		return 0, ErrUnreachable
+	}
+	return t, nil
+}
+
+// Forward returns the effector position for motor angles a.
+func (g Geometry) Forward(a Angles) (x, y, z float64, err error) {
+	if !finite(a[:]...) {
+		return 0, 0, 0, ErrUnreachable
+	}
+	// Each lower arm constrains the effector centre to a sphere of radius
+	// LowerArm about its elbow, pulled in by EffectorRadius.
+	var c [3]vec
+	for i, u := range axes {
+		r := g.BaseRadius + g.UpperArm*math.Cos(a[i]) - g.EffectorRadius
+		c[i] = vec{r * u[0], r * u[1], -g.UpperArm * math.Sin(a[i])}
+	}
+
+	p, err := trilaterate(c, g.LowerArm)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	if !finite(p[:]...) {
+		return 0, 0, 0, ErrUnreachable
+	}
+	return p[0], p[1], p[2] + g.Home, nil
+}
+
+// finite reports whether none of vs is NaN or infinite. Comparisons with NaN
+// are false, so the reach checks alone let it through.
+func finite(vs ...float64) bool {
+	for _, v := range vs {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// trilaterate returns the lower intersection of three spheres of radius r.
+func trilaterate(c [3]vec, r float64) (vec, error) {
+	d := c[1].sub(c[0]).norm()
+	if d == 0 {
+		return vec{}, ErrUnreachable
+	}
+	ex := c[1].sub(c[0]).scale(1 / d)
+	c3 := c[2].sub(c[0])
+	i := ex.dot(c3)
+	ey := c3.sub(ex.scale(i))
+	j := ey.norm()
+	if j == 0 {
+		return vec{}, ErrUnreachable
+	}
+	ey = ey.scale(1 / j)
+	ez := ex.cross(ey)
+
+	px := d / 2
+	py := (i*i+j*j)/(2*j) - i/j*px
+	pz2 := r*r - px*px - py*py
+	if pz2 < 0 {
+		return vec{}, ErrUnreachable
+	}
+	pz := math.Sqrt(pz2)
+
+	p := c[0].add(ex.scale(px)).add(ey.scale(py))
+	p1 := p.add(ez.scale(pz))
+	p2 := p.sub(ez.scale(pz))
+	if p1[2] < p2[2] {
+		return p1, nil
+	}
+	return p2, nil
+}
+
+type vec [3]float64
+
+func (a vec) add(b vec) vec       { return vec{a[0] + b[0], a[1] + b[1], a[2] + b[2]} }
+func (a vec) sub(b vec) vec       { return vec{a[0] - b[0], a[1] - b[1], a[2] - b[2]} }
+func (a vec) scale(k float64) vec { return vec{a[0] * k, a[1] * k, a[2] * k} }
+func (a vec) dot(b vec) float64   { return a[0]*b[0] + a[1]*b[1] + a[2]*b[2] }
+func (a vec) norm() float64       { return math.Sqrt(a.dot(a)) }
+func (a vec) cross(b vec) vec {
+	return vec{
+		a[1]*b[2] - a[2]*b[1],
+		a[2]*b[0] - a[0]*b[2],
+		a[0]*b[1] - a[1]*b[0],
+	}
+}
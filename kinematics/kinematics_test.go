@@ -0,0 +1,123 @@
+package kinematics
+
+import (
+	"math"
+	"testing"
+)
+
+const tolerance = 1e-9 // metres or radians
+
+func TestRoundTrip(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		g    Geometry
+	}{
+		{"default", Default},
+		{"wide effector", Geometry{BaseRadius: 0.1, EffectorRadius: 0.05, UpperArm: 0.12, LowerArm: 0.3, Home: 0.25}},
+	} {
+		// A 5 cm cube about the origin, 5 mm apart
+		n := 0
+		for x := -0.05; x <= 0.05; x += 0.005 {
+			for y := -0.05; y <= 0.05; y += 0.005 {
+				for z := -0.05; z <= 0.05; z += 0.005 {
+					a, err := tt.g.Inverse(x, y, z)
+					if err != nil {
+						t.Errorf("%s: Inverse(%v, %v, %v): %v", tt.name, x, y, z, err)
+						continue
+					}
+					fx, fy, fz, err := tt.g.Forward(a)
+					if err != nil {
+						t.Errorf("%s: Forward(%v): %v", tt.name, a, err)
+						continue
+					}
+					if d := math.Sqrt(sq(fx-x) + sq(fy-y) + sq(fz-z)); d > tolerance {
+						t.Errorf("%s: Forward(Inverse(%v, %v, %v)) = (%v, %v, %v), off by %g", tt.name, x, y, z, fx, fy, fz, d)
+					}
+					n++
+				}
+			}
+		}
+		if n == 0 {
+			t.Errorf("%s: no points checked", tt.name)
+		}
+	}
+}
+
+func TestRoundTripAngles(t *testing.T) {
+	g := Default
+	for _, a := range []Angles{
+		{0, 0, 0},
+		{0.3, 0.3, 0.3},
+		{0.2, 0.5, 0.8},
+		{-0.2, 0.1, 0.4},
+	} {
+		x, y, z, err := g.Forward(a)
+		if err != nil {
+			t.Errorf("Forward(%v): %v", a, err)
+			continue
+		}
+		b, err := g.Inverse(x, y, z)
+		if err != nil {
+			t.Errorf("Inverse(Forward(%v)): %v", a, err)
+			continue
+		}
+		for i := range a {
+			if math.Abs(a[i]-b[i]) > tolerance {
+				t.Errorf("Inverse(Forward(%v)) = %v", a, b)
+				break
+			}
+		}
+	}
+}
+
+func TestHome(t *testing.T) {
+	// The origin is straight below the base, so every arm is alike
+	a, err := Default.Inverse(0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(a[0]-a[1]) > tolerance || math.Abs(a[0]-a[2]) > tolerance {
+		t.Errorf("Inverse(0, 0, 0) = %v, want equal angles", a)
+	}
+}
+
+func TestUnreachable(t *testing.T) {
+	inf, nan := math.Inf(1), math.NaN()
+	for _, p := range [][3]float64{
+		{1, 0, 0},
+		{0, 0, -1},
+		{0, 0, Default.Home}, // the base
+		{inf, 0, 0},
+		{0, math.Inf(-1), 0},
+		{0, 0, inf},
+		{nan, 0, 0},
+		{0, nan, 0},
+		{0, 0, nan},
+		{math.MaxFloat64, 0, 0},
+	} {
+		a, err := Default.Inverse(p[0], p[1], p[2])
+		if err != ErrUnreachable {
+			t.Errorf("Inverse(%v) = %v, %v, want ErrUnreachable", p, a, err)
+		}
+	}
+
+	for _, a := range []Angles{
+		{nan, 0, 0},
+		{0, inf, 0},
+		{0, 0, math.Inf(-1)},
+	} {
+		x, y, z, err := Default.Forward(a)
+		if err != ErrUnreachable {
+			t.Errorf("Forward(%v) = (%v, %v, %v), %v, want ErrUnreachable", a, x, y, z, err)
+		}
+	}
+
+	// Lower arms too short to meet
+	short := Default
+	short.LowerArm = 0.05
+	if x, y, z, err := short.Forward(Angles{}); err != ErrUnreachable {
+		t.Errorf("Forward with short lower arms = (%v, %v, %v), %v, want ErrUnreachable", x, y, z, err)
+	}
+}
+
+func sq(x float64) float64 { return x * x }
@@ -2,67 +2,218 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"log"
 	"math"
 	"net"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
+	"github.com/afking/godelta/client"
+	"github.com/afking/godelta/config"
 	"github.com/afking/godelta/delta"
+	"github.com/afking/godelta/gcode"
+	"github.com/afking/godelta/plot"
+	"github.com/afking/godelta/record"
+	"github.com/afking/godelta/sim"
+	"github.com/afking/godelta/stream"
+	"github.com/afking/godelta/trajectory"
+	"github.com/afking/godelta/workspace"
 	"github.com/golang/protobuf/proto"
 
 	"github.com/codegangsta/cli"
 )
 
 const (
-	TIMEOUT time.Duration = time.Second * 4
-
-	CONN_LOCL string = "192.168.1.100"
-	CONN_MATL string = "127.0.0.1"
-
-	CONN_HOST string = "192.168.1.10"
-	CONN_PORT string = "80" //"2616"
-	CONN_TYPE string = "tcp"
+	MOTORS int32 = 3
 )
 
 var (
-	conn *net.TCPConn
-	add  *net.TCPAddr
-	err  error
+	err error
+
+	arm *client.Client
+	ws  *workspace.Workspace
+	cfg = config.Default()
+
+	// recorder, if set, records the session of the running command
+	recorder *record.Writer
+
+	// cmdCtx is cancelled when the running command should stop
+	cmdCtx = context.Background()
 )
 
-func TCP() {
-	add, err = net.ResolveTCPAddr(CONN_TYPE, CONN_HOST+":"+CONN_PORT)
+// TCP connects to the arm configured by ac and checks it answers a ping,
+// giving up after the timeout or when ctx is done. Connection changes are
+// logged with name.
+func TCP(ctx context.Context, name string, ac *config.Config) (*client.Client, error) {
+	prefix := "connection"
+	if name != "" {
+		prefix = name + ": connection"
+	}
+	d := &client.Dialer{
+		PingInterval: ac.PingInterval,
+		OnState: func(s client.State, err error) {
+			switch {
+			case err == client.ErrClosed:
+			case err != nil:
+				log.Printf("%s %s: %v", prefix, s, err)
+			default:
+				log.Printf("%s %s", prefix, s)
+			}
+		},
+	}
+	if recorder != nil {
+		d.Trace = recorder.Trace
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ac.Timeout)
+	defer cancel()
+	a, err := d.Dial(ctx, ac.Addr)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	a.Timeout = ac.Timeout
+	a.Ack = ac.Ack
+	a.Workspace = ws
+	if _, err := a.Ping(ctx); err != nil {
+		a.Close()
+		return nil, fmt.Errorf("handshake with %s: %v", ac.Addr, err)
 	}
+	return a, nil
+}
 
-	status := make(chan bool, 1)
-	go func() {
-		conn, err = net.DialTCP(CONN_TYPE, nil, add)
-		if err != nil {
-			log.Fatal(err)
+// armNames resolves the arm flag to profile names: one name, a comma
+// separated group or all. No flag means the top level settings, named "".
+func armNames(c *cli.Context) ([]string, error) {
+	switch v := c.GlobalString("arm"); v {
+	case "":
+		return []string{""}, nil
+	case "all":
+		names := cfg.ArmNames()
+		if len(names) == 0 {
+			return nil, fmt.Errorf("no arms configured")
 		}
-		status <- true
-	}()
+		return names, nil
+	default:
+		names := strings.Split(v, ",")
+		for _, name := range names {
+			if _, err := cfg.Arm(name); err != nil {
+				return nil, err
+			}
+		}
+		return names, nil
+	}
+}
+
+// commandContext returns the context a command runs in, cancelled by Ctrl-C
+// or the deadline flag
+func commandContext(c *cli.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if d := c.GlobalDuration("deadline"); d > 0 {
+		ctx, cancel = context.WithTimeout(ctx, d)
+	}
+	stop := interrupt(cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
 
+// interrupt calls cancel on the first Ctrl-C or SIGTERM, a second Ctrl-C
+// exits
+func interrupt(cancel func()) (stop func()) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
 	go func() {
-		time.Sleep(TIMEOUT)
-		status <- false
+		select {
+		case <-sig:
+			log.Println("interrupted, press Ctrl-C again to exit")
+			signal.Stop(sig)
+			cancel()
+		case <-done:
+		}
 	}()
+	return func() {
+		signal.Stop(sig)
+		close(done)
+	}
+}
 
-	if state := <-status; !state {
-		log.Fatal(fmt.Errorf("TCP timeout"))
+// configFlags are global flags overriding config settings
+var configFlags = []struct {
+	flag, key string
+}{
+	{"addr", "addr"},
+	{"timeout", "timeout"},
+	{"ack", "ack"},
+	{"workspace", "workspace.bounds"},
+	{"workspace-mode", "workspace.mode"},
+}
+
+// loadConfig reads the config file and environment, then applies flags and
+// checks the result
+func loadConfig(c *cli.Context) error {
+	var err error
+	if cfg, err = config.Load(c.String("config")); err != nil {
+		return err
 	}
+	for _, f := range configFlags {
+		if c.IsSet(f.flag) {
+			if err := cfg.Set(f.key, c.String(f.flag)); err != nil {
+				return err
+			}
+		}
+	}
+	for _, kv := range c.StringSlice("set") {
+		s := strings.SplitN(kv, "=", 2)
+		if len(s) != 2 {
+			return fmt.Errorf("invalid setting %q, want key=value", kv)
+		}
+		if err := cfg.Set(s[0], s[1]); err != nil {
+			return err
+		}
+	}
+	return cfg.Check()
+}
 
-	if err := conn.SetKeepAlive(true); err != nil {
+// showConfig prints the effective configuration
+func showConfig(c *cli.Context) {
+	fmt.Println("# effective configuration")
+	if err := cfg.Encode(os.Stdout); err != nil {
 		log.Fatal(err)
 	}
-	if err := conn.SetKeepAlivePeriod(TIMEOUT); err != nil {
-		log.Fatal(err)
+}
+
+// setWorkspace configures the POINT workspace from global flags
+func setWorkspace(c *cli.Context) error {
+	w := cfg.Workspace
+	mode, err := workspace.ParseMode(w.Mode)
+	if err != nil {
+		return err
+	}
+
+	ws = &workspace.Workspace{Mode: mode}
+	switch name := w.Bounds; name {
+	case "none":
+		ws.Bounds = nil
+	case "reach":
+		ws.Bounds = workspace.Reach{Geometry: cfg.Geometry}
+	case "cylinder":
+		ws.Bounds = workspace.Cylinder{Radius: w.Radius, ZMin: w.ZMin, ZMax: w.ZMax}
+	case "sphere":
+		ws.Bounds = workspace.Sphere{X: w.CenterX, Y: w.CenterY, Z: w.CenterZ, Radius: w.Radius}
+	default:
+		return fmt.Errorf("unknown workspace %q", name)
 	}
+	return nil
 }
 
 /*
@@ -76,40 +227,12 @@ func handleConn(conn1 net.Conn) {
 	log.Printf("%i bytes read\n", n)
 }
 */
-func read(msg *delta.Message) error {
-	var buf bytes.Buffer
-	n, err := io.Copy(&buf, conn)
-	if err != nil {
-		return err
-	}
-	log.Printf("%d bytes read\n", n)
-	return proto.Unmarshal(buf.Bytes(), msg)
-	/*
-		data := make([]byte, 128)
-		//if err := conn.SetReadDeadline(time.Now().Add(TIMEOUT)); err != nil {
-		//	return nil, err
-		//}
-		n, err := conn.Read(data)
-		if err != nil {
-			return err
-		}
-		log.Printf("%i bytes readq\n", n)
-		return proto.Unmarshal(data, msg)
-	*/
+func read() (*delta.Message, error) {
+	return arm.Recv(cmdCtx)
 }
 
 func write(msg *delta.Message) error {
-	data, err := proto.Marshal(msg)
-	if err != nil {
-		return err
-	}
-
-	n, err := conn.Write(data)
-	if err != nil {
-		return err
-	}
-	log.Printf("%d bytes written\n", n)
-	return nil
+	return arm.Send(cmdCtx, msg)
 }
 
 func msgType(t delta.Message_Type) error {
@@ -122,93 +245,329 @@ func msgType(t delta.Message_Type) error {
 
 func msgPoint(x, y, z float64) error {
 	log.Printf("POINT(%f, %f, %f)", x, y, z)
-	msg := &delta.Message{
-		Type: delta.Message_POINT.Enum(),
-		Point: &delta.Point{
-			X: &x,
-			Y: &y,
-			Z: &z,
-		},
-	}
-
-	return write(msg)
+	return arm.MoveTo(cmdCtx, x, y, z)
 }
 
 // e wraps errors for TCP application commands
 func e(f func(*cli.Context) error) func(*cli.Context) {
 	return func(c *cli.Context) {
-		TCP() // Setup
-		if err := f(c); err != nil {
+		names, err := armNames(c)
+		if err != nil {
+			log.Println("error: ", err)
+			return
+		}
+		if len(names) > 1 {
+			log.Printf("error: %s takes a single arm", c.Command.Name)
+			return
+		}
+		ac, _ := cfg.Arm(names[0])
+		if err := setWorkspace(c); err != nil {
+			log.Println("error: ", err)
+			return
+		}
+
+		ctx, cancel := commandContext(c)
+		defer cancel()
+		cmdCtx = ctx
+
+		if path := c.GlobalString("record"); path != "" {
+			if recorder, err = record.Create(path); err != nil {
+				log.Println("error: ", err)
+				return
+			}
+			defer func() {
+				if err := recorder.Close(); err != nil {
+					log.Println("record: ", err)
+				}
+			}()
+		}
+		if arm, err = TCP(ctx, names[0], ac); err != nil {
+			log.Println("error: ", err)
+			return
+		}
+		defer arm.Close()
+		defer func() {
+			if n := ws.Rejected(); n > 0 {
+				log.Printf("workspace: %d points rejected", n)
+			}
+		}()
+		if err := f(c); err != nil && err != context.Canceled {
 			log.Println("error: ", err)
 			return
 		}
 	}
 }
 
-// ping delta arm robot
-func ping(c *cli.Context) error {
-	ping := delta.Message_PING
-	msg := &delta.Message{
-		Type: &ping,
-	}
-	fmt.Println("Struct type: ", msg.GetType().String())
+// armCmd is a command any arm of a group can run, returning what to report
+type armCmd func(ctx context.Context, a *client.Client) (string, error)
 
-	startTime := time.Now()
-	if err = write(msg); err != nil {
-		return err
-	}
-	rsp := &delta.Message{}
-	if err := read(rsp); err != nil {
-		return err
+// g wraps commands that fan out to every arm named by the arm flag,
+// reporting each arm's result
+func g(f armCmd) func(*cli.Context) {
+	return func(c *cli.Context) {
+		names, err := armNames(c)
+		if err != nil {
+			log.Println("error: ", err)
+			return
+		}
+		if err := setWorkspace(c); err != nil {
+			log.Println("error: ", err)
+			return
+		}
+		ctx, cancel := commandContext(c)
+		defer cancel()
+
+		type result struct {
+			out string
+			err error
+		}
+		results := make([]result, len(names))
+		var wg sync.WaitGroup
+		for i, name := range names {
+			wg.Add(1)
+			go func(i int, name string) {
+				defer wg.Done()
+				r := &results[i]
+				ac, _ := cfg.Arm(name)
+				a, err := TCP(ctx, name, ac)
+				if err != nil {
+					r.err = err
+					return
+				}
+				defer a.Close()
+				r.out, r.err = f(ctx, a)
+			}(i, name)
+		}
+		wg.Wait()
+
+		if len(names) == 1 {
+			if r := results[0]; r.err != nil {
+				log.Println("error: ", r.err)
+			} else {
+				fmt.Println(r.out)
+			}
+			return
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		for i, name := range names {
+			if r := results[i]; r.err != nil {
+				fmt.Fprintf(tw, "%s\terror: %v\n", name, r.err)
+			} else {
+				fmt.Fprintf(tw, "%s\t%s\n", name, r.out)
+			}
+		}
+		tw.Flush()
 	}
-	endTime := time.Now()
+}
 
-	if rsp.GetType() == msg.GetType() {
-		fmt.Println("pong [%v]", endTime.Sub(startTime))
-	} else {
-		return fmt.Errorf("Invalid type received %i", rsp.GetType().String())
+// ping delta arm robot
+func ping(ctx context.Context, a *client.Client) (string, error) {
+	rtt, err := a.Ping(ctx)
+	if err != nil {
+		return "", err
 	}
-	return nil
+	return fmt.Sprintf("pong [%v]", rtt), nil
 }
 
-func start(c *cli.Context) error {
-	return msgType(delta.Message_START)
+func start(ctx context.Context, a *client.Client) (string, error) {
+	return "started", a.Start(ctx)
 }
-func stop(c *cli.Context) error {
-	return msgType(delta.Message_STOP)
+func stop(ctx context.Context, a *client.Client) (string, error) {
+	return "stopped", a.Stop(ctx)
 }
 func point(c *cli.Context) error {
 	return msgPoint(0.02, 0.02, 0.0)
 }
 func xbox(c *cli.Context) error {
-	return xboxDriver()
+	ctrl := cfg.Controller
+	if c.IsSet("rate") {
+		ctrl.Rate = c.Int("rate")
+	}
+	return xboxDriver(ctrl, limits(c))
 }
-func set(c *cli.Context) error {
-	return nil // TODO
+func circle(c *cli.Context) error {
+	l := limits(c)
+	p, err := period(c)
+	if err != nil {
+		return err
+	}
+	const r = 0.04 // m
+
+	var loop []trajectory.Point
+	for i := 0; i <= 720; i++ {
+		t := float64(i) * math.Pi / 180
+		loop = append(loop, trajectory.Point{X: math.Sin(t) * r, Y: math.Cos(t) * r})
+	}
+	home := trajectory.Point{}
+
+	// Ease in from home, go around twice and ease back out
+	in, err := trajectory.New([]trajectory.Point{home, loop[0]}, l)
+	if err != nil {
+		return err
+	}
+	round, err := trajectory.New(loop, l)
+	if err != nil {
+		return err
+	}
+	out, err := trajectory.New([]trajectory.Point{loop[len(loop)-1], home}, l)
+	if err != nil {
+		return err
+	}
+
+	t := trajectory.Join(in, round, out)
+	log.Printf("circle: %v", t.Duration())
+	return play(t, p)
 }
-func get(c *cli.Context) error {
-	return msgType(delta.Message_GET)
+
+// play streams a trajectory to the arm at a fixed rate. Each tick of the
+// streamer sends the setpoint at the time elapsed, so a late tick skips ahead
+// rather than slowing the motion down.
+func play(t *trajectory.Trajectory, period time.Duration) error {
+	s, err := stream.NewSampler(period, func(d time.Duration) (x, y, z float64, last bool) {
+		p := t.At(d)
+		if d >= t.Duration() {
+			p, last = t.End(), true
+		}
+		return p.X, p.Y, p.Z, last
+	}, func(x, y, z float64) error {
+		heart.Beat()
+		return msgPoint(x, y, z)
+	})
+	if err != nil {
+		return err
+	}
+	err = s.Run(cmdCtx)
+	log.Println("stream:", s.Stats())
+	return err
 }
-func circle(c *cli.Context) error {
-	ts := time.Now()
-	for time.Since(ts).Seconds() < math.Pi*4 {
-		t := time.Since(ts).Seconds()
-		if err := msgPoint(math.Sin(t)*0.04, math.Cos(t)*0.04, 0); err != nil {
-			log.Println(err)
+
+// run a gcode program
+func run(c *cli.Context) error {
+	if len(c.Args()) != 1 {
+		return fmt.Errorf("usage: delta run file.gcode")
+	}
+	origin, err := parsePoint(c.String("origin"))
+	if err != nil {
+		return err
+	}
+	p, err := period(c)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(c.Args().First())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	in := &gcode.Interpreter{Origin: origin}
+	moves, err := in.Parse(f)
+	if err != nil {
+		return err
+	}
+	for _, m := range moves {
+		if err := checkPath(m.Path); err != nil {
+			return fmt.Errorf("line %d: %v", m.Line, err)
 		}
-		time.Sleep(time.Millisecond * 3)
+	}
+	t, err := gcode.Plan(moves, limits(c))
+	if err != nil {
+		return err
+	}
+
+	log.Printf("run: %d moves, %v", len(moves), t.Duration())
+	return play(t, p)
+}
+
+// plotter draws an svg file with a pen
+func plotter(c *cli.Context) error {
+	if len(c.Args()) != 1 {
+		return fmt.Errorf("usage: delta plot drawing.svg")
+	}
+	var r plot.Region
+	if _, err := fmt.Sscanf(c.String("region"), "%g,%g,%g,%g", &r.MinX, &r.MinY, &r.MaxX, &r.MaxY); err != nil {
+		return fmt.Errorf("invalid region %q, want minx,miny,maxx,maxy", c.String("region"))
+	}
+	p, err := period(c)
+	if err != nil {
+		return err
 	}
 
-	if err := msgPoint(0, 0, 0); err != nil {
+	f, err := os.Open(c.Args().First())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	paths, err := plot.Parse(f)
+	if err != nil {
+		return err
+	}
+	lines, err := plot.Fit(paths, r, c.Float64("tolerance"))
+	if err != nil {
+		return err
+	}
+	pen := plot.Pen{Up: c.Float64("pen-up"), Down: c.Float64("pen-down")}
+	t, err := plot.Toolpath(lines, pen, limits(c))
+	if err != nil {
+		return err
+	}
+	if err := checkPath(t.Sample(p)); err != nil {
 		return err
 	}
 
+	log.Printf("plot: %d lines, %v", len(lines), t.Duration())
+	return play(t, p)
+}
+
+// checkPath rejects a path leaving the workspace before anything is sent, so
+// a program is not abandoned half way
+func checkPath(path []trajectory.Point) error {
+	if ws.Bounds == nil || ws.Mode != workspace.Reject {
+		return nil
+	}
+	for _, p := range path {
+		if !ws.Bounds.Contains(p.X, p.Y, p.Z) {
+			return &workspace.OutsideError{X: p.X, Y: p.Y, Z: p.Z}
+		}
+	}
 	return nil
 }
-func proxy(c *cli.Context) error {
-	TCP() // INIT
 
-	addr, err := net.ResolveUDPAddr("udp", ":8080")
+// parsePoint parses "x,y,z" in metres
+func parsePoint(s string) (trajectory.Point, error) {
+	var p trajectory.Point
+	if _, err := fmt.Sscanf(s, "%g,%g,%g", &p.X, &p.Y, &p.Z); err != nil {
+		return p, fmt.Errorf("invalid point %q, want x,y,z", s)
+	}
+	return p, nil
+}
+
+// limits reads motion limit flags
+func limits(c *cli.Context) trajectory.Limits {
+	return trajectory.Limits{
+		Velocity:     c.Float64("velocity"),
+		Acceleration: c.Float64("acceleration"),
+		Jerk:         c.Float64("jerk"),
+	}
+}
+
+// maxRate is the highest setpoint rate, in Hz, a 1 ms period. Faster than
+// this the setpoints only queue up in the arm's small receive buffer.
+const maxRate = 1000
+
+// period of the setpoint rate flag
+func period(c *cli.Context) (time.Duration, error) {
+	hz := c.Int("rate")
+	if hz <= 0 || hz > maxRate {
+		return 0, fmt.Errorf("invalid rate %d, want 1 to %d Hz", hz, maxRate)
+	}
+	return time.Second / time.Duration(hz), nil
+}
+
+func proxy(c *cli.Context) error {
+	addr, err := net.ResolveUDPAddr("udp", cfg.Proxy)
 	if err != nil {
 		return err
 	}
@@ -217,10 +576,17 @@ func proxy(c *cli.Context) error {
 		return err
 	}
 	defer udp.Close()
+	go func() {
+		<-cmdCtx.Done()
+		udp.Close()
+	}()
 
 	for {
 		var buf bytes.Buffer
 		n, err := io.Copy(&buf, udp)
+		if cmdCtx.Err() != nil {
+			return cmdCtx.Err()
+		}
 		if err != nil {
 			return err
 		}
@@ -238,13 +604,24 @@ func proxy(c *cli.Context) error {
 }
 
 func listen(c *cli.Context) error {
-	msg := &delta.Message{}
 	for {
-		if err := read(msg); err != nil {
-			log.Println("Listen: Error: ", err)
-		} else {
-			log.Println("Listen: Got type: ", msg.GetType().String())
+		msg, err := read()
+		if err != nil {
+			return err
 		}
+		log.Println("Listen: Got type: ", msg.GetType().String())
+	}
+}
+
+func simulate(c *cli.Context) {
+	s := sim.New()
+	addr := cfg.Sim
+	if c.IsSet("addr") {
+		addr = c.String("addr")
+	}
+	log.Println("Simulating delta arm on", addr)
+	if err := s.ListenAndServe(addr); err != nil {
+		log.Fatal(err)
 	}
 }
 
@@ -273,6 +650,32 @@ func test(c *cli.Context) {
 	log.Printf("Unmarshalled to type: %q, info: %q", rsp.GetType().String(), rsp.GetInfo())
 }
 
+// motionFlags configure trajectory limits and setpoint rate
+var motionFlags = []cli.Flag{
+	cli.Float64Flag{
+		Name:  "velocity",
+		Value: 0.04,
+		Usage: "max velocity, m/s",
+	},
+	cli.Float64Flag{
+		Name:  "acceleration",
+		Value: 0.2,
+		Usage: "max acceleration, m/s^2",
+	},
+	cli.Float64Flag{
+		Name:  "jerk",
+		Value: 2,
+		Usage: "max jerk, m/s^3, 0 for trapezoidal",
+	},
+	rateFlag,
+}
+
+var rateFlag = cli.IntFlag{
+	Name:  "rate",
+	Value: 333,
+	Usage: "setpoint rate, Hz",
+}
+
 func main() {
 	app := cli.NewApp()
 	app.Name = "delta"
@@ -280,35 +683,84 @@ func main() {
 	app.Action = func(c *cli.Context) {
 		fmt.Println("Go Delta Arm Client")
 	}
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:  "config",
+			Usage: "config file, default $DELTA_CONFIG or " + config.DefaultPath(),
+		},
+		cli.StringSliceFlag{
+			Name:  "set",
+			Usage: "override a config setting, key=value",
+		},
+		cli.StringFlag{
+			Name:  "addr",
+			Usage: "delta arm address",
+		},
+		cli.StringFlag{
+			Name:  "arm",
+			Usage: "arm profile, a comma separated group or all; ping, start and stop run on every arm in a group",
+		},
+		cli.BoolFlag{
+			Name:  "ack",
+			Usage: "wait for the arm to acknowledge commands",
+		},
+		cli.DurationFlag{
+			Name:  "timeout",
+			Usage: "connection and request timeout",
+		},
+		cli.DurationFlag{
+			Name:  "deadline",
+			Usage: "stop the command after this long, 0 for no limit",
+		},
+		cli.StringFlag{
+			Name:  "record",
+			Usage: "record messages sent and received to a session file",
+		},
+		cli.StringFlag{
+			Name:  "workspace",
+			Usage: "POINT bounds: reach, cylinder, sphere or none",
+		},
+		cli.StringFlag{
+			Name:  "workspace-mode",
+			Usage: "points outside the workspace: reject, clamp or warn",
+		},
+	}
+	app.Before = loadConfig
 	app.Commands = []cli.Command{
 		{
 			Name:    "ping",
 			Aliases: []string{"p"},
 			Usage:   "ping message to delta arm robot",
-			Action:  e(ping),
+			Action:  g(ping),
 		},
 		{
 			Name:    "start",
 			Aliases: []string{"s"},
 			Usage:   "start allows motor positioning commands",
-			Action:  e(start),
+			Action:  g(start),
 		},
 		{
 			Name:    "stop",
 			Aliases: []string{"h"},
 			Usage:   "stop ignores motor positioning commands",
-			Action:  e(stop),
+			Action:  g(stop),
 		},
 		{
 			Name:   "point",
 			Usage:  "send point command",
-			Action: e(point),
+			Action: e(safe(point)),
 		},
 		{
 			Name:    "xbox",
 			Aliases: []string{"x"},
 			Usage:   "xbox control",
-			Action:  e(xbox),
+			Action:  e(safe(xbox)),
+			Flags:   motionFlags,
+		},
+		{
+			Name:   "gamepads",
+			Usage:  "list pads for controller.input = \"evdev\"",
+			Action: gamepads,
 		},
 		{
 			Name:    "listen",
@@ -327,22 +779,113 @@ func main() {
 			Aliases: []string{"s"},
 			Usage:   "set motoro data",
 			Action:  e(set),
+			Flags:   setFlags(),
 		},
 		{
 			Name:    "get",
 			Aliases: []string{"g"},
 			Usage:   "get motoro data",
 			Action:  e(get),
+			Flags:   getFlags(),
 		},
 		{
 			Name:   "circle",
 			Usage:  "make a circle",
-			Action: e(circle),
+			Action: e(safe(circle)),
+			Flags:  motionFlags,
+		},
+		{
+			Name:   "run",
+			Usage:  "run a gcode program",
+			Action: e(safe(run)),
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "origin",
+					Value: "0,0,0",
+					Usage: "position of program zero, m",
+				},
+			}, motionFlags...),
+		},
+		{
+			Name:   "plot",
+			Usage:  "draw an svg file with a pen",
+			Action: e(safe(plotter)),
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "region",
+					Value: "-0.03,-0.03,0.03,0.03",
+					Usage: "drawing area minx,miny,maxx,maxy, m",
+				},
+				cli.Float64Flag{
+					Name:  "tolerance",
+					Value: 0.0001,
+					Usage: "curve flattening tolerance, m",
+				},
+				cli.Float64Flag{
+					Name:  "pen-up",
+					Value: 0.01,
+					Usage: "pen up height, m",
+				},
+				cli.Float64Flag{
+					Name:  "pen-down",
+					Value: 0,
+					Usage: "pen down height, m",
+				},
+			}, motionFlags...),
 		},
 		{
 			Name:   "proxy",
 			Usage:  "proxy matlab commands to points commands",
-			Action: e(proxy),
+			Action: e(safe(proxy)),
+		},
+		{
+			Name:   "replay",
+			Usage:  "resend the messages sent in a recorded session",
+			Action: e(safe(replay)),
+			Flags: []cli.Flag{
+				cli.Float64Flag{
+					Name:  "speed",
+					Value: 1,
+					Usage: "playback speed, 2 is twice as fast",
+				},
+				cli.BoolFlag{
+					Name:  "step",
+					Usage: "wait for enter before each message",
+				},
+			},
+		},
+		{
+			Name:   "decode",
+			Usage:  "print the messages in a session, capture or frame stream file",
+			Action: decode,
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:  "port",
+					Usage: "only decode pcap streams to or from this TCP port",
+				},
+			},
+		},
+		{
+			Name:  "config",
+			Usage: "configuration",
+			Subcommands: []cli.Command{
+				{
+					Name:   "show",
+					Usage:  "print the effective configuration",
+					Action: showConfig,
+				},
+			},
+		},
+		{
+			Name:   "sim",
+			Usage:  "simulate a delta arm",
+			Action: simulate,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "addr",
+					Usage: "listen address, default from config",
+				},
+			},
 		},
 	}
 
@@ -351,10 +894,12 @@ func main() {
 			fmt.Println("Panic ", r)
 		}
 
-		if conn != nil {
-			conn.Close()
+		if arm != nil {
+			arm.Close()
 		}
 	}()
 
-	app.Run(os.Args)
+	if err := app.Run(os.Args); err != nil {
+		log.Println("error: ", err)
+	}
 }
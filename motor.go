@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/afking/godelta/client"
+	"github.com/afking/godelta/delta"
+
+	"github.com/codegangsta/cli"
+)
+
+// motorFields are the configurable delta.Motor fields
+var motorFields = []struct {
+	name  string
+	usage string
+	field func(*delta.Motor) **int32
+}{
+	{"p", "proportional gain", func(m *delta.Motor) **int32 { return &m.P }},
+	{"i", "integral gain", func(m *delta.Motor) **int32 { return &m.I }},
+	{"d", "derivative gain", func(m *delta.Motor) **int32 { return &m.D }},
+	{"position", "goal position", func(m *delta.Motor) **int32 { return &m.Position }},
+	{"velocity", "moving speed", func(m *delta.Motor) **int32 { return &m.Velocity }},
+	{"torque", "torque limit", func(m *delta.Motor) **int32 { return &m.Torque }},
+	{"punch", "minimum drive current", func(m *delta.Motor) **int32 { return &m.Punch }},
+}
+
+func setFlags() []cli.Flag {
+	flags := []cli.Flag{
+		cli.IntFlag{
+			Name:  "motor, m",
+			Usage: "motor id",
+		},
+	}
+	for _, f := range motorFields {
+		flags = append(flags, cli.IntFlag{
+			Name:  f.name,
+			Usage: f.usage,
+		})
+	}
+	return flags
+}
+
+// set motor configuration, then read it back to verify
+func set(c *cli.Context) error {
+	if !c.IsSet("motor") {
+		return fmt.Errorf("usage: delta set --motor id [--p n] [--i n] ...")
+	}
+	id := int32(c.Int("motor"))
+	m := &delta.Motor{Id: &id}
+	for _, f := range motorFields {
+		if c.IsSet(f.name) {
+			v := int32(c.Int(f.name))
+			*f.field(m) = &v
+		}
+	}
+
+	msg := &delta.Message{
+		Type:  delta.Message_SET.Enum(),
+		Motor: m,
+	}
+	if _, err := arm.Call(cmdCtx, msg); err != nil {
+		if _, ok := err.(*client.ArmError); ok {
+			return err
+		}
+		log.Println("set: no acknowledgement: ", err)
+	}
+
+	got, err := arm.GetMotor(cmdCtx, id)
+	if err != nil {
+		return fmt.Errorf("verify: %v", err)
+	}
+
+	bad := 0
+	for _, f := range motorFields {
+		want := *f.field(m)
+		if want == nil {
+			continue
+		}
+		v := *f.field(got)
+		switch {
+		case v == nil:
+			fmt.Printf("%-8s = %d, not reported\n", f.name, *want)
+			bad++
+		case *v != *want:
+			fmt.Printf("%-8s = %d, want %d\n", f.name, *v, *want)
+			bad++
+		default:
+			fmt.Printf("%-8s = %d\n", f.name, *v)
+		}
+	}
+	if bad > 0 {
+		return fmt.Errorf("motor %d: %d fields mismatch", id, bad)
+	}
+	return nil
+}
+
+// getMotors reads the addressed motors, all of them without --motor
+func getMotors(c *cli.Context) ([]*delta.Motor, error) {
+	ids := []int32{}
+	if c.IsSet("motor") {
+		ids = append(ids, int32(c.Int("motor")))
+	} else {
+		for id := int32(1); id <= MOTORS; id++ {
+			ids = append(ids, id)
+		}
+	}
+
+	var ms []*delta.Motor
+	for _, id := range ids {
+		m, err := arm.GetMotor(cmdCtx, id)
+		if err != nil {
+			return nil, fmt.Errorf("motor %d: %v", id, err)
+		}
+		ms = append(ms, m)
+	}
+	return ms, nil
+}
+
+// get motor telemetry, optionally polling
+func get(c *cli.Context) error {
+	format := c.String("format")
+	switch format {
+	case "table", "json", "csv":
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+	if !c.Bool("watch") {
+		ms, err := getMotors(c)
+		if err != nil {
+			return err
+		}
+		return printMotors(os.Stdout, format, ms, true)
+	}
+
+	interval := c.Duration("interval")
+	if interval <= 0 {
+		return fmt.Errorf("invalid interval %v, want a positive duration", interval)
+	}
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for first := true; ; first = false {
+		ms, err := getMotors(c)
+		if err != nil {
+			log.Println("get: ", err)
+		} else {
+			if format == "table" {
+				fmt.Print("\033[H\033[2J") // clear screen
+				fmt.Println(time.Now().Format(time.StampMilli))
+			}
+			if err := printMotors(os.Stdout, format, ms, first); err != nil {
+				return err
+			}
+		}
+		select {
+		case <-tick.C:
+		case <-cmdCtx.Done():
+			return nil
+		}
+	}
+}
+
+// printMotors writes motors as a table, a json array or csv rows
+func printMotors(w io.Writer, format string, ms []*delta.Motor, header bool) error {
+	cols := []string{"id"}
+	for _, f := range motorFields {
+		cols = append(cols, f.name)
+	}
+	row := func(m *delta.Motor) []string {
+		r := []string{strconv.Itoa(int(m.GetId()))}
+		for _, f := range motorFields {
+			if v := *f.field(m); v != nil {
+				r = append(r, strconv.Itoa(int(*v)))
+			} else {
+				r = append(r, "")
+			}
+		}
+		return r
+	}
+
+	switch format {
+	case "json":
+		data, err := json.Marshal(ms)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", data)
+		return err
+	case "csv":
+		cw := csv.NewWriter(w)
+		if header {
+			cw.Write(append([]string{"time"}, cols...))
+		}
+		now := time.Now().Format(time.RFC3339Nano)
+		for _, m := range ms {
+			cw.Write(append([]string{now}, row(m)...))
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, strings.Join(cols, "\t")+"\t")
+	for _, m := range ms {
+		fmt.Fprintln(tw, strings.Join(row(m), "\t")+"\t")
+	}
+	return tw.Flush()
+}
+
+func getFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.IntFlag{
+			Name:  "motor, m",
+			Usage: "motor id, all motors if unset",
+		},
+		cli.StringFlag{
+			Name:  "format, f",
+			Value: "table",
+			Usage: "output format: table, json or csv",
+		},
+		cli.BoolFlag{
+			Name:  "watch, w",
+			Usage: "poll and redraw",
+		},
+		cli.DurationFlag{
+			Name:  "interval",
+			Value: time.Second,
+			Usage: "watch polling interval",
+		},
+	}
+}
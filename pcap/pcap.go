@@ -0,0 +1,291 @@
+// Package pcap extracts TCP streams from libpcap capture files.
+//
+// Only the classic file format is read, with Ethernet, loopback, raw IP and
+// Linux cooked captures of IPv4 or IPv6 without extension headers. Streams
+// are reassembled by sequence number; segments that arrive early are held
+// until the data before them, retransmitted bytes are dropped and missing
+// ones are counted as gaps.
+package pcap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"time"
+)
+
+// ErrFormat is returned for files that are not pcap captures.
+var ErrFormat = errors.New("pcap: not a capture file")
+
+// IsCapture reports whether b starts with a pcap magic number.
+func IsCapture(b []byte) bool {
+	if len(b) < 4 {
+		return false
+	}
+	switch binary.LittleEndian.Uint32(b) {
+	case magicMicro, magicNano, swap(magicMicro), swap(magicNano):
+		return true
+	}
+	return false
+}
+
+const (
+	magicMicro = 0xa1b2c3d4
+	magicNano  = 0xa1b23c4d
+)
+
+func swap(v uint32) uint32 {
+	return v>>24 | v>>8&0xff00 | v<<8&0xff0000 | v<<24
+}
+
+// Link types.
+const (
+	linkNull   = 0
+	linkEther  = 1
+	linkRaw    = 101
+	linkRawAlt = 12
+	linkSLL    = 113
+	linkSLL2   = 276
+)
+
+// Stream is the payload sent one way on a TCP connection.
+type Stream struct {
+	Src, Dst string // ip:port
+	Data     []byte
+	Gaps     int // holes where segments were not captured
+
+	segs   []seg
+	next   uint32 // next expected sequence number
+	held   []held // segments after next, in arrival order
+	listed bool
+}
+
+// seg marks when the data from off onwards arrived.
+type seg struct {
+	off  int
+	time time.Time
+}
+
+// held is a segment that arrived ahead of the data before it.
+type held struct {
+	seq  uint32
+	data []byte
+	time time.Time
+}
+
+// maxHeld is how many early segments a stream holds before giving up on the
+// data missing in front of them.
+const maxHeld = 64
+
+// TimeAt returns when byte off of the stream was captured.
+func (s *Stream) TimeAt(off int) time.Time {
+	i := sort.Search(len(s.segs), func(i int) bool { return s.segs[i].off > off })
+	if i == 0 {
+		return time.Time{}
+	}
+	return s.segs[i-1].time
+}
+
+// Streams reads a capture and returns its TCP streams with data, in the
+// order they started.
+func Streams(r io.Reader) ([]*Stream, error) {
+	var hdr [24]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, ErrFormat
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	magic := order.Uint32(hdr[:])
+	if magic == swap(magicMicro) || magic == swap(magicNano) {
+		order = binary.BigEndian
+		magic = swap(magic)
+	}
+	if magic != magicMicro && magic != magicNano {
+		return nil, ErrFormat
+	}
+	link := order.Uint32(hdr[20:])
+
+	var list []*Stream
+	flows := map[string]*Stream{}
+	// done appends what is still held after the last gaps
+	done := func() []*Stream {
+		var streams []*Stream
+		for _, s := range list {
+			if s.take(true); len(s.Data) > 0 {
+				streams = append(streams, s)
+			}
+		}
+		return streams
+	}
+	for {
+		var rec [16]byte
+		if _, err := io.ReadFull(r, rec[:]); err == io.EOF {
+			return done(), nil
+		} else if err != nil {
+			return done(), fmt.Errorf("pcap: truncated record header")
+		}
+		sec, frac := order.Uint32(rec[0:]), order.Uint32(rec[4:])
+		incl, orig := order.Uint32(rec[8:]), order.Uint32(rec[12:])
+		if incl > 1<<18 {
+			return done(), fmt.Errorf("pcap: record of %d bytes", incl)
+		}
+		pkt := make([]byte, incl)
+		if _, err := io.ReadFull(r, pkt); err != nil {
+			return done(), fmt.Errorf("pcap: truncated record")
+		}
+
+		ns := int64(frac) * 1000
+		if magic == magicNano {
+			ns = int64(frac)
+		}
+		t := time.Unix(int64(sec), ns)
+
+		src, dst, seq, syn, payload, ok := tcp(link, pkt)
+		if !ok {
+			continue
+		}
+		key := src + " > " + dst
+		s := flows[key]
+		if s == nil {
+			s = &Stream{Src: src, Dst: dst, next: seq}
+			flows[key] = s
+		}
+		if syn {
+			s.next = seq + 1
+			continue
+		}
+		if incl < orig {
+			s.Gaps++ // cut short by the snap length
+		}
+		if len(payload) == 0 {
+			continue
+		}
+		if !s.listed {
+			s.listed = true
+			list = append(list, s)
+		}
+		s.held = append(s.held, held{seq: seq, data: payload, time: t})
+		s.take(len(s.held) > maxHeld)
+	}
+}
+
+// take appends the held segments that continue the data. With skip it also
+// skips over gaps to the earliest held segment until none are left.
+func (s *Stream) take(skip bool) {
+	for len(s.held) > 0 {
+		i := 0
+		for j, h := range s.held {
+			if int32(h.seq-s.next) < int32(s.held[i].seq-s.next) {
+				i = j
+			}
+		}
+		h := s.held[i]
+		d := int32(h.seq - s.next)
+		if d > 0 {
+			if !skip {
+				return
+			}
+			s.Gaps++
+			s.next, d = h.seq, 0
+		}
+		s.held = append(s.held[:i], s.held[i+1:]...)
+		if int(-d) >= len(h.data) {
+			continue // retransmission
+		}
+		p := h.data[-d:] // keep any new tail
+		s.segs = append(s.segs, seg{off: len(s.Data), time: h.time})
+		s.Data = append(s.Data, p...)
+		s.next += uint32(len(p))
+	}
+}
+
+// tcp returns the endpoints, sequence number and payload of a TCP packet.
+func tcp(link uint32, pkt []byte) (src, dst string, seq uint32, syn bool, payload []byte, ok bool) {
+	var proto uint16
+	switch link {
+	case linkEther:
+		if len(pkt) < 14 {
+			return
+		}
+		proto, pkt = binary.BigEndian.Uint16(pkt[12:]), pkt[14:]
+		if proto == 0x8100 && len(pkt) >= 4 { // VLAN tag
+			proto, pkt = binary.BigEndian.Uint16(pkt[2:]), pkt[4:]
+		}
+	case linkNull:
+		if len(pkt) < 4 {
+			return
+		}
+		pkt = pkt[4:]
+		proto = ipVersion(pkt)
+	case linkRaw, linkRawAlt:
+		proto = ipVersion(pkt)
+	case linkSLL:
+		if len(pkt) < 16 {
+			return
+		}
+		proto, pkt = binary.BigEndian.Uint16(pkt[14:]), pkt[16:]
+	case linkSLL2:
+		if len(pkt) < 20 {
+			return
+		}
+		proto, pkt = binary.BigEndian.Uint16(pkt[0:]), pkt[20:]
+	default:
+		return
+	}
+
+	var sip, dip net.IP
+	switch proto {
+	case 0x0800:
+		if len(pkt) < 20 || pkt[9] != 6 {
+			return
+		}
+		ihl := int(pkt[0]&0x0f) * 4
+		total := int(binary.BigEndian.Uint16(pkt[2:]))
+		if ihl < 20 || total < ihl || len(pkt) < ihl {
+			return
+		}
+		if total < len(pkt) {
+			pkt = pkt[:total] // drop link padding
+		}
+		sip, dip, pkt = net.IP(pkt[12:16]), net.IP(pkt[16:20]), pkt[ihl:]
+	case 0x86dd:
+		if len(pkt) < 40 || pkt[6] != 6 {
+			return
+		}
+		if n := 40 + int(binary.BigEndian.Uint16(pkt[4:])); n < len(pkt) {
+			pkt = pkt[:n]
+		}
+		sip, dip, pkt = net.IP(pkt[8:24]), net.IP(pkt[24:40]), pkt[40:]
+	default:
+		return
+	}
+
+	if len(pkt) < 20 {
+		return
+	}
+	off := int(pkt[12]>>4) * 4
+	if off < 20 || len(pkt) < off {
+		return
+	}
+	src = net.JoinHostPort(sip.String(), fmt.Sprint(binary.BigEndian.Uint16(pkt[0:])))
+	dst = net.JoinHostPort(dip.String(), fmt.Sprint(binary.BigEndian.Uint16(pkt[2:])))
+	seq = binary.BigEndian.Uint32(pkt[4:])
+	syn = pkt[13]&0x02 != 0
+	return src, dst, seq, syn, pkt[off:], true
+}
+
+// ipVersion returns the ethertype for a bare IP packet.
+func ipVersion(pkt []byte) uint16 {
+	if len(pkt) == 0 {
+		return 0
+	}
+	switch pkt[0] >> 4 {
+	case 4:
+		return 0x0800
+	case 6:
+		return 0x86dd
+	}
+	return 0
+}
@@ -0,0 +1,182 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// packet is a TCP segment from 10.0.0.1:5000 to 10.0.0.2:port.
+type packet struct {
+	port uint16
+	seq  uint32
+	syn  bool
+	data string
+}
+
+// capture returns a little endian microsecond capture of raw IPv4 packets,
+// one a millisecond.
+func capture(pkts ...packet) []byte {
+	var b bytes.Buffer
+	hdr := make([]byte, 24)
+	binary.LittleEndian.PutUint32(hdr[0:], magicMicro)
+	binary.LittleEndian.PutUint16(hdr[4:], 2)
+	binary.LittleEndian.PutUint16(hdr[6:], 4)
+	binary.LittleEndian.PutUint32(hdr[16:], 65535)
+	binary.LittleEndian.PutUint32(hdr[20:], linkRaw)
+	b.Write(hdr)
+
+	for i, p := range pkts {
+		ip := make([]byte, 40, 40+len(p.data))
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:], uint16(40+len(p.data)))
+		ip[9] = 6
+		copy(ip[12:], []byte{10, 0, 0, 1})
+		copy(ip[16:], []byte{10, 0, 0, 2})
+		tcp := ip[20:]
+		binary.BigEndian.PutUint16(tcp[0:], 5000)
+		binary.BigEndian.PutUint16(tcp[2:], p.port)
+		binary.BigEndian.PutUint32(tcp[4:], p.seq)
+		tcp[12] = 5 << 4
+		if p.syn {
+			tcp[13] = 0x02
+		}
+		ip = append(ip, p.data...)
+
+		rec := make([]byte, 16)
+		binary.LittleEndian.PutUint32(rec[4:], uint32(i*1000))
+		binary.LittleEndian.PutUint32(rec[8:], uint32(len(ip)))
+		binary.LittleEndian.PutUint32(rec[12:], uint32(len(ip)))
+		b.Write(rec)
+		b.Write(ip)
+	}
+	return b.Bytes()
+}
+
+func TestStreams(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		pkts []packet
+		data string
+		gaps int
+	}{
+		{"in order", []packet{
+			{port: 80, seq: 99, syn: true},
+			{port: 80, seq: 100, data: "abc"},
+			{port: 80, seq: 103, data: "def"},
+		}, "abcdef", 0},
+		{"out of order", []packet{
+			{port: 80, seq: 100, data: "abc"},
+			{port: 80, seq: 106, data: "ghi"},
+			{port: 80, seq: 103, data: "def"},
+		}, "abcdefghi", 0},
+		{"retransmission", []packet{
+			{port: 80, seq: 100, data: "abc"},
+			{port: 80, seq: 100, data: "abc"},
+			{port: 80, seq: 103, data: "def"},
+		}, "abcdef", 0},
+		{"overlap", []packet{
+			{port: 80, seq: 100, data: "abc"},
+			{port: 80, seq: 102, data: "cde"},
+		}, "abcde", 0},
+		{"gap", []packet{
+			{port: 80, seq: 100, data: "abc"},
+			{port: 80, seq: 106, data: "ghi"},
+		}, "abcghi", 1},
+		{"gap then reordered", []packet{
+			{port: 80, seq: 100, data: "abc"},
+			{port: 80, seq: 109, data: "jkl"},
+			{port: 80, seq: 106, data: "ghi"},
+		}, "abcghijkl", 1},
+		{"sequence wrap", []packet{
+			{port: 80, seq: 1<<32 - 2, data: "ab"},
+			{port: 80, seq: 1, data: "d"},
+			{port: 80, seq: 0, data: "c"},
+		}, "abcd", 0},
+	} {
+		streams, err := Streams(bytes.NewReader(capture(tt.pkts...)))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(streams) != 1 {
+			t.Errorf("%s: %d streams, want 1", tt.name, len(streams))
+			continue
+		}
+		s := streams[0]
+		if string(s.Data) != tt.data || s.Gaps != tt.gaps {
+			t.Errorf("%s: %q with %d gaps, want %q with %d", tt.name, s.Data, s.Gaps, tt.data, tt.gaps)
+		}
+		if s.Src != "10.0.0.1:5000" || s.Dst != "10.0.0.2:80" {
+			t.Errorf("%s: %s > %s", tt.name, s.Src, s.Dst)
+		}
+	}
+}
+
+func TestStreamsOrder(t *testing.T) {
+	streams, err := Streams(bytes.NewReader(capture(
+		packet{port: 2, seq: 0, syn: true},
+		packet{port: 1, seq: 10, data: "one"},
+		packet{port: 2, seq: 1, data: "two"},
+		packet{port: 1, seq: 13, data: "!"},
+		packet{port: 3, seq: 0, syn: true}, // no data
+	)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, s := range streams {
+		got = append(got, string(s.Data))
+	}
+	if len(got) != 2 || got[0] != "one!" || got[1] != "two" {
+		t.Errorf("Streams = %q, want [one! two]", got)
+	}
+}
+
+func TestTimeAt(t *testing.T) {
+	streams, err := Streams(bytes.NewReader(capture(
+		packet{port: 80, seq: 100, data: "ab"},
+		packet{port: 80, seq: 104, data: "ef"},
+		packet{port: 80, seq: 102, data: "cd"},
+	)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := streams[0]
+	for _, tt := range []struct {
+		off  int
+		want time.Duration
+	}{
+		{0, 0},
+		{1, 0},
+		{2, 2 * time.Millisecond}, // "cd" came last
+		{4, time.Millisecond},
+	} {
+		if got := s.TimeAt(tt.off).Sub(time.Unix(0, 0)); got != tt.want {
+			t.Errorf("TimeAt(%d) = %v, want %v", tt.off, got, tt.want)
+		}
+	}
+}
+
+func TestStreamsTruncated(t *testing.T) {
+	b := capture(
+		packet{port: 80, seq: 100, data: "abc"},
+		packet{port: 80, seq: 106, data: "ghi"},
+		packet{port: 80, seq: 109, data: "jkl"},
+	)
+	streams, err := Streams(bytes.NewReader(b[:len(b)-2]))
+	if err == nil {
+		t.Error("truncated capture read without error")
+	}
+	if len(streams) != 1 || string(streams[0].Data) != "abcghi" || streams[0].Gaps != 1 {
+		t.Errorf("Streams = %v, want abcghi with 1 gap", streams)
+	}
+
+	if _, err := Streams(bytes.NewReader([]byte("not a capture file at all"))); err != ErrFormat {
+		t.Errorf("Streams of text = %v, want ErrFormat", err)
+	}
+	if !IsCapture(b) || IsCapture([]byte{0x0a, 0x08}) {
+		t.Error("IsCapture wrong")
+	}
+}
@@ -0,0 +1,412 @@
+package plot
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Point is a 2D position.
+type Point struct {
+	X, Y float64
+}
+
+// Polyline is a flattened subpath.
+type Polyline []Point
+
+// cubic is a Bézier segment; lines and quadratics are stored as cubics.
+type cubic [4]Point
+
+// subpath is a run of segments started by a moveto.
+type subpath struct {
+	start Point
+	segs  []cubic
+}
+
+// Path is parsed SVG path data.
+type Path struct {
+	subs []subpath
+}
+
+// ParsePath parses SVG path data, the d attribute of a path element.
+func ParsePath(d string) (*Path, error) {
+	p := &pathParser{s: d}
+	if err := p.parse(); err != nil {
+		return nil, fmt.Errorf("plot: path: %v", err)
+	}
+	return &Path{subs: p.subs}, nil
+}
+
+type pathParser struct {
+	s    string
+	i    int
+	subs []subpath
+
+	cur, start Point
+	ctrl       Point // last control point, for S and T
+	last       byte  // last command, upper case
+}
+
+func (p *pathParser) skip() {
+	for p.i < len(p.s) {
+		switch p.s[p.i] {
+		case ' ', '\t', '\n', '\r', ',':
+			p.i++
+		default:
+			return
+		}
+	}
+}
+
+func (p *pathParser) num() (float64, error) {
+	p.skip()
+	j := p.i
+	if j < len(p.s) && (p.s[j] == '-' || p.s[j] == '+') {
+		j++
+	}
+	dot, exp := false, false
+scan:
+	for ; j < len(p.s); j++ {
+		c := p.s[j]
+		switch {
+		case c >= '0' && c <= '9':
+		case c == '.' && !dot && !exp:
+			dot = true
+		case (c == 'e' || c == 'E') && !exp:
+			exp = true
+			if j+1 < len(p.s) && (p.s[j+1] == '-' || p.s[j+1] == '+') {
+				j++
+			}
+		default:
+			break scan
+		}
+	}
+	v, err := strconv.ParseFloat(p.s[p.i:j], 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad number at offset %d", p.i)
+	}
+	p.i = j
+	return v, nil
+}
+
+func (p *pathParser) flag() (bool, error) {
+	p.skip()
+	if p.i < len(p.s) && (p.s[p.i] == '0' || p.s[p.i] == '1') {
+		p.i++
+		return p.s[p.i-1] == '1', nil
+	}
+	return false, fmt.Errorf("bad flag at offset %d", p.i)
+}
+
+// nums reads n numbers.
+func (p *pathParser) nums(n int) ([]float64, error) {
+	v := make([]float64, n)
+	for k := range v {
+		var err error
+		if v[k], err = p.num(); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+func (p *pathParser) parse() error {
+	var cmd byte
+	for {
+		p.skip()
+		if p.i >= len(p.s) {
+			return nil
+		}
+		if c := p.s[p.i]; (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+			cmd = c
+			p.i++
+		} else if cmd == 0 {
+			return fmt.Errorf("path must start with a command")
+		}
+		if err := p.command(cmd); err != nil {
+			return err
+		}
+		// Repeated coordinates after a moveto are linetos.
+		switch cmd {
+		case 'M':
+			cmd = 'L'
+		case 'm':
+			cmd = 'l'
+		case 'Z', 'z':
+			cmd = 0
+		}
+	}
+}
+
+func (p *pathParser) command(cmd byte) error {
+	rel := cmd >= 'a'
+	up := cmd
+	if rel {
+		up -= 'a' - 'A'
+	}
+	abs := func(x, y float64) Point {
+		if rel {
+			return Point{p.cur.X + x, p.cur.Y + y}
+		}
+		return Point{x, y}
+	}
+
+	switch up {
+	case 'Z':
+		if p.cur != p.start {
+			p.line(p.start)
+		}
+		p.cur = p.start
+		p.last = up
+		return nil
+	case 'H', 'V':
+		v, err := p.num()
+		if err != nil {
+			return err
+		}
+		to := p.cur
+		switch {
+		case up == 'H' && rel:
+			to.X += v
+		case up == 'H':
+			to.X = v
+		case rel:
+			to.Y += v
+		default:
+			to.Y = v
+		}
+		p.line(to)
+	case 'M', 'L', 'T':
+		v, err := p.nums(2)
+		if err != nil {
+			return err
+		}
+		to := abs(v[0], v[1])
+		switch up {
+		case 'M':
+			p.subs = append(p.subs, subpath{start: to})
+			p.cur, p.start = to, to
+		case 'L':
+			p.line(to)
+		case 'T':
+			c := p.reflect('Q', 'T')
+			p.quad(c, to)
+		}
+	case 'Q', 'S':
+		v, err := p.nums(4)
+		if err != nil {
+			return err
+		}
+		c, to := abs(v[0], v[1]), abs(v[2], v[3])
+		if up == 'Q' {
+			p.quad(c, to)
+		} else {
+			p.cubic(p.reflect('C', 'S'), c, to)
+		}
+	case 'C':
+		v, err := p.nums(6)
+		if err != nil {
+			return err
+		}
+		p.cubic(abs(v[0], v[1]), abs(v[2], v[3]), abs(v[4], v[5]))
+	case 'A':
+		v, err := p.nums(3)
+		if err != nil {
+			return err
+		}
+		large, err := p.flag()
+		if err != nil {
+			return err
+		}
+		sweep, err := p.flag()
+		if err != nil {
+			return err
+		}
+		e, err := p.nums(2)
+		if err != nil {
+			return err
+		}
+		p.arc(v[0], v[1], v[2], large, sweep, abs(e[0], e[1]))
+	default:
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+	p.last = up
+	return nil
+}
+
+// reflect returns the implied first control point of a smooth curve, which
+// mirrors the last control point if the previous command was a or b.
+func (p *pathParser) reflect(a, b byte) Point {
+	if p.last != a && p.last != b {
+		return p.cur
+	}
+	return Point{2*p.cur.X - p.ctrl.X, 2*p.cur.Y - p.ctrl.Y}
+}
+
+// add appends a segment, opening a subpath if there is none.
+func (p *pathParser) add(c cubic) {
+	if len(p.subs) == 0 {
+		p.subs = append(p.subs, subpath{start: p.cur})
+		p.start = p.cur
+	}
+	s := &p.subs[len(p.subs)-1]
+	s.segs = append(s.segs, c)
+	p.cur = c[3]
+}
+
+func (p *pathParser) line(to Point) {
+	a := p.cur
+	p.add(cubic{a, lerp(a, to, 1.0/3), lerp(a, to, 2.0/3), to})
+	p.ctrl = to
+}
+
+func (p *pathParser) quad(c, to Point) {
+	a := p.cur
+	p.add(cubic{a, lerp(a, c, 2.0/3), lerp(to, c, 2.0/3), to})
+	p.ctrl = c
+}
+
+func (p *pathParser) cubic(c1, c2, to Point) {
+	p.add(cubic{p.cur, c1, c2, to})
+	p.ctrl = c2
+}
+
+// arc converts an elliptical arc to cubics, following the SVG
+// implementation notes for endpoint to centre parameterisation.
+func (p *pathParser) arc(rx, ry, rot float64, large, sweep bool, to Point) {
+	from := p.cur
+	rx, ry = math.Abs(rx), math.Abs(ry)
+	if rx == 0 || ry == 0 || from == to {
+		p.line(to)
+		return
+	}
+	phi := rot * math.Pi / 180
+	cos, sin := math.Cos(phi), math.Sin(phi)
+
+	dx, dy := (from.X-to.X)/2, (from.Y-to.Y)/2
+	x1 := cos*dx + sin*dy
+	y1 := -sin*dx + cos*dy
+
+	// Scale up radii too small to span the endpoints.
+	if l := x1*x1/(rx*rx) + y1*y1/(ry*ry); l > 1 {
+		rx, ry = rx*math.Sqrt(l), ry*math.Sqrt(l)
+	}
+
+	num := rx*rx*ry*ry - rx*rx*y1*y1 - ry*ry*x1*x1
+	den := rx*rx*y1*y1 + ry*ry*x1*x1
+	k := math.Sqrt(math.Max(0, num/den))
+	if large == sweep {
+		k = -k
+	}
+	cx1, cy1 := k*rx*y1/ry, -k*ry*x1/rx
+	cx := cos*cx1 - sin*cy1 + (from.X+to.X)/2
+	cy := sin*cx1 + cos*cy1 + (from.Y+to.Y)/2
+
+	angle := func(ux, uy, vx, vy float64) float64 {
+		return math.Atan2(ux*vy-uy*vx, ux*vx+uy*vy)
+	}
+	t0 := angle(1, 0, (x1-cx1)/rx, (y1-cy1)/ry)
+	dt := angle((x1-cx1)/rx, (y1-cy1)/ry, (-x1-cx1)/rx, (-y1-cy1)/ry)
+	if !sweep && dt > 0 {
+		dt -= 2 * math.Pi
+	} else if sweep && dt < 0 {
+		dt += 2 * math.Pi
+	}
+
+	// Each cubic spans at most a quarter turn.
+	n := int(math.Ceil(math.Abs(dt) / (math.Pi / 2)))
+	step := dt / float64(n)
+	h := 4.0 / 3 * math.Tan(step/4)
+	pt := func(t float64) (Point, Point) {
+		ct, st := math.Cos(t), math.Sin(t)
+		x, y := rx*ct, ry*st
+		ddx, ddy := -rx*st, ry*ct // derivative
+		return Point{cos*x - sin*y + cx, sin*x + cos*y + cy},
+			Point{cos*ddx - sin*ddy, sin*ddx + cos*ddy}
+	}
+	t := t0
+	for i := 0; i < n; i++ {
+		a, da := pt(t)
+		b, db := pt(t + step)
+		if i == n-1 {
+			b = to
+		}
+		p.add(cubic{
+			p.cur,
+			{a.X + h*da.X, a.Y + h*da.Y},
+			{b.X - h*db.X, b.Y - h*db.Y},
+			b,
+		})
+		t += step
+	}
+	p.ctrl = p.cur
+}
+
+func lerp(a, b Point, t float64) Point {
+	return Point{a.X + (b.X-a.X)*t, a.Y + (b.Y-a.Y)*t}
+}
+
+// bounds returns the box around the segment control points, which contains
+// the path.
+func (pa *Path) bounds() (min, max Point, ok bool) {
+	min = Point{math.Inf(1), math.Inf(1)}
+	max = Point{math.Inf(-1), math.Inf(-1)}
+	for _, s := range pa.subs {
+		ps := []Point{s.start}
+		for _, c := range s.segs {
+			ps = append(ps, c[:]...)
+		}
+		for _, q := range ps {
+			min.X, min.Y = math.Min(min.X, q.X), math.Min(min.Y, q.Y)
+			max.X, max.Y = math.Max(max.X, q.X), math.Max(max.Y, q.Y)
+			ok = true
+		}
+	}
+	return min, max, ok
+}
+
+// Flatten returns the path as polylines, each within tol of the curves.
+func (pa *Path) Flatten(tol float64) []Polyline {
+	var ls []Polyline
+	for _, s := range pa.subs {
+		l := Polyline{s.start}
+		for _, c := range s.segs {
+			l = flatten(l, c, tol, 0)
+		}
+		if len(l) > 1 {
+			ls = append(ls, l)
+		}
+	}
+	return ls
+}
+
+// flatten appends the end points of a subdivision of c.
+func flatten(l Polyline, c cubic, tol float64, depth int) Polyline {
+	if depth > 16 || flat(c, tol) {
+		return append(l, c[3])
+	}
+	// de Casteljau split at t = 0.5
+	ab, bc, cd := lerp(c[0], c[1], .5), lerp(c[1], c[2], .5), lerp(c[2], c[3], .5)
+	abc, bcd := lerp(ab, bc, .5), lerp(bc, cd, .5)
+	m := lerp(abc, bcd, .5)
+	l = flatten(l, cubic{c[0], ab, abc, m}, tol, depth+1)
+	return flatten(l, cubic{m, bcd, cd, c[3]}, tol, depth+1)
+}
+
+// flat reports whether the control points are within tol of the chord.
+func flat(c cubic, tol float64) bool {
+	dx, dy := c[3].X-c[0].X, c[3].Y-c[0].Y
+	d := math.Hypot(dx, dy)
+	for _, q := range c[1:3] {
+		var e float64
+		if d == 0 {
+			e = math.Hypot(q.X-c[0].X, q.Y-c[0].Y)
+		} else {
+			e = math.Abs((q.X-c[0].X)*dy-(q.Y-c[0].Y)*dx) / d
+		}
+		if e > tol {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,288 @@
+package plot
+
+import (
+	"math"
+	"testing"
+)
+
+// ends returns each subpath as its start and segment end points.
+func ends(p *Path) [][]Point {
+	var ls [][]Point
+	for _, s := range p.subs {
+		l := []Point{s.start}
+		for _, c := range s.segs {
+			l = append(l, c[3])
+		}
+		ls = append(ls, l)
+	}
+	return ls
+}
+
+func near(p, q Point) bool {
+	const tolerance = 1e-12
+	return math.Abs(p.X-q.X) < tolerance && math.Abs(p.Y-q.Y) < tolerance
+}
+
+// at returns the point at t along c.
+func (c cubic) at(t float64) Point {
+	u := 1 - t
+	a, b, d, e := u*u*u, 3*u*u*t, 3*u*t*t, t*t*t
+	return Point{
+		a*c[0].X + b*c[1].X + d*c[2].X + e*c[3].X,
+		a*c[0].Y + b*c[1].Y + d*c[2].Y + e*c[3].Y,
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	for _, tt := range []struct {
+		d    string
+		want [][]Point
+	}{
+		{"M1 2 L3 4", [][]Point{{{1, 2}, {3, 4}}}},
+		{"m1 2 l3 4", [][]Point{{{1, 2}, {4, 6}}}},
+		{"M0 0 H5 V5 h-2 v-1", [][]Point{{{0, 0}, {5, 0}, {5, 5}, {3, 5}, {3, 4}}}},
+		// Coordinates after a moveto are linetos
+		{"M0 0 1 1 2 0", [][]Point{{{0, 0}, {1, 1}, {2, 0}}}},
+		{"m1 1 1 1 1 -1", [][]Point{{{1, 1}, {2, 2}, {3, 1}}}},
+		{"M0 0 L1 1 2 0", [][]Point{{{0, 0}, {1, 1}, {2, 0}}}},
+		// Close, and relative moves from the closed subpath's start
+		{"M0 0 L1 0 L1 1 Z", [][]Point{{{0, 0}, {1, 0}, {1, 1}, {0, 0}}}},
+		{"M0 0 L1 0 L0 0 z", [][]Point{{{0, 0}, {1, 0}, {0, 0}}}},
+		{"M1 1 l1 0 z m1 1 l1 0", [][]Point{{{1, 1}, {2, 1}, {1, 1}}, {{2, 2}, {3, 2}}}},
+		{"M1 1 L2 1 Z L1 2", [][]Point{{{1, 1}, {2, 1}, {1, 1}, {1, 2}}}},
+		// Compact number syntax
+		{"M0-1.5.5.5L1e1,2", [][]Point{{{0, -1.5}, {0.5, 0.5}, {10, 2}}}},
+		{"M+1,2E-1\tl-1-2e-1", [][]Point{{{1, 0.2}, {0, 0}}}},
+		// A drawing command with no moveto starts at the origin
+		{"L1 1", [][]Point{{{0, 0}, {1, 1}}}},
+		{"", nil},
+		{"M1 1", [][]Point{{{1, 1}}}},
+	} {
+		p, err := ParsePath(tt.d)
+		if err != nil {
+			t.Errorf("%q: %v", tt.d, err)
+			continue
+		}
+		got := ends(p)
+		ok := len(got) == len(tt.want)
+		for i := 0; ok && i < len(got); i++ {
+			ok = len(got[i]) == len(tt.want[i])
+			for j := 0; ok && j < len(got[i]); j++ {
+				ok = near(got[i][j], tt.want[i][j])
+			}
+		}
+		if !ok {
+			t.Errorf("%q = %v, want %v", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestParseCurves(t *testing.T) {
+	const third = 1.0 / 3
+	for _, tt := range []struct {
+		d    string
+		want []cubic
+	}{
+		{"M0 0 C1 1 2 1 3 0", []cubic{{{0, 0}, {1, 1}, {2, 1}, {3, 0}}}},
+		{"m1 1 c1 1 2 1 3 0", []cubic{{{1, 1}, {2, 2}, {3, 2}, {4, 1}}}},
+		// S mirrors the last control point of a C or S
+		{"M0 0 C0 1 2 1 2 0 S4 -1 4 0", []cubic{
+			{{0, 0}, {0, 1}, {2, 1}, {2, 0}},
+			{{2, 0}, {2, -1}, {4, -1}, {4, 0}},
+		}},
+		{"M0 0 C0 1 2 1 2 0 s2 -1 2 0", []cubic{
+			{{0, 0}, {0, 1}, {2, 1}, {2, 0}},
+			{{2, 0}, {2, -1}, {4, -1}, {4, 0}},
+		}},
+		// but not of anything else
+		{"M0 0 S1 1 2 0", []cubic{{{0, 0}, {0, 0}, {1, 1}, {2, 0}}}},
+		{"M0 0 Q1 1 2 0 S3 1 4 0", []cubic{
+			{{0, 0}, {2 * third, 2 * third}, {4 * third, 2 * third}, {2, 0}},
+			{{2, 0}, {2, 0}, {3, 1}, {4, 0}},
+		}},
+		// Quadratics are raised to cubics
+		{"M0 0 Q1 1 2 0", []cubic{{{0, 0}, {2 * third, 2 * third}, {4 * third, 2 * third}, {2, 0}}}},
+		{"m0 0 q1 1 2 0", []cubic{{{0, 0}, {2 * third, 2 * third}, {4 * third, 2 * third}, {2, 0}}}},
+		// T mirrors the last control point of a Q or T
+		{"M0 0 Q1 1 2 0 T4 0", []cubic{
+			{{0, 0}, {2 * third, 2 * third}, {4 * third, 2 * third}, {2, 0}},
+			{{2, 0}, {8 * third, -2 * third}, {10 * third, -2 * third}, {4, 0}},
+		}},
+		{"M0 0 Q1 1 2 0 t2 0", []cubic{
+			{{0, 0}, {2 * third, 2 * third}, {4 * third, 2 * third}, {2, 0}},
+			{{2, 0}, {8 * third, -2 * third}, {10 * third, -2 * third}, {4, 0}},
+		}},
+		{"M0 0 T3 0", []cubic{{{0, 0}, {0, 0}, {1, 0}, {3, 0}}}},
+		{"M0 0 L1 0 2 0", []cubic{
+			{{0, 0}, {third, 0}, {2 * third, 0}, {1, 0}},
+			{{1, 0}, {1 + third, 0}, {1 + 2*third, 0}, {2, 0}},
+		}},
+	} {
+		p, err := ParsePath(tt.d)
+		if err != nil {
+			t.Errorf("%q: %v", tt.d, err)
+			continue
+		}
+		if len(p.subs) != 1 || len(p.subs[0].segs) != len(tt.want) {
+			t.Errorf("%q = %v, want one subpath %v", tt.d, p.subs, tt.want)
+			continue
+		}
+		for i, c := range p.subs[0].segs {
+			for j := range c {
+				if !near(c[j], tt.want[i][j]) {
+					t.Errorf("%q: segment %d = %v, want %v", tt.d, i, c, tt.want[i])
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestParseArc(t *testing.T) {
+	for _, tt := range []struct {
+		d      string
+		centre Point
+		r      float64
+		sweep  float64 // radians, positive from +x towards +y
+	}{
+		{"M1 0 A1 1 0 0 1 0 1", Point{0, 0}, 1, math.Pi / 2},
+		{"M1 0 A1 1 0 1 0 0 1", Point{0, 0}, 1, -3 * math.Pi / 2},
+		{"M1 0 A1 1 0 0 0 0 1", Point{1, 1}, 1, -math.Pi / 2},
+		{"M1 0 A1 1 0 1 1 0 1", Point{1, 1}, 1, 3 * math.Pi / 2},
+		// Flags need no separators
+		{"M1 0 a1,1,0,10-1,1", Point{0, 0}, 1, -3 * math.Pi / 2},
+		{"M1 0a1 1 0 11-1 1", Point{1, 1}, 1, 3 * math.Pi / 2},
+		// Radii too small are scaled up to span the ends
+		{"M0 0 A0.1 0.1 0 0 1 2 0", Point{1, 0}, 1, math.Pi},
+		{"M0 0 A-1 -1 0 0 1 2 0", Point{1, 0}, 1, math.Pi},
+	} {
+		p, err := ParsePath(tt.d)
+		if err != nil {
+			t.Errorf("%q: %v", tt.d, err)
+			continue
+		}
+		ls := p.Flatten(1e-4)
+		if len(ls) != 1 {
+			t.Errorf("%q = %v, want one polyline", tt.d, ls)
+			continue
+		}
+		sweep := 0.0
+		for i, q := range ls[0] {
+			if d := math.Hypot(q.X-tt.centre.X, q.Y-tt.centre.Y); math.Abs(d-tt.r) > 1e-3 {
+				t.Errorf("%q: point %d %v is %v from %v, want %v", tt.d, i, q, d, tt.centre, tt.r)
+				break
+			}
+			if i > 0 {
+				o := ls[0][i-1]
+				a := math.Atan2(q.Y-tt.centre.Y, q.X-tt.centre.X) - math.Atan2(o.Y-tt.centre.Y, o.X-tt.centre.X)
+				sweep += math.Remainder(a, 2*math.Pi)
+			}
+		}
+		if math.Abs(sweep-tt.sweep) > 1e-9 {
+			t.Errorf("%q: sweeps %v, want %v", tt.d, sweep, tt.sweep)
+		}
+	}
+
+	// A rotated ellipse, rx along y
+	p, err := ParsePath("M0 0 A2 1 90 0 1 0 4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range p.Flatten(1e-4)[0] {
+		if e := q.X*q.X + (q.Y-2)*(q.Y-2)/4; math.Abs(e-1) > 1e-3 {
+			t.Errorf("rotated ellipse point %v is off the ellipse", q)
+			break
+		}
+	}
+
+	// A zero radius or a zero length arc is a line
+	for _, d := range []string{"M0 0 A0 1 0 0 1 2 0", "M0 0 A1 1 0 0 1 0 0"} {
+		p, err := ParsePath(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := p.subs[0].segs; len(s) != 1 || !near(s[0][1], lerp(s[0][0], s[0][3], 1.0/3)) {
+			t.Errorf("%q = %v, want a line", d, s)
+		}
+	}
+}
+
+func TestParsePathErrors(t *testing.T) {
+	for _, tt := range []struct {
+		d   string
+		msg string
+	}{
+		{"1 2", "plot: path: path must start with a command"},
+		{"M1", "plot: path: bad number at offset 2"},
+		{"M1 2 L", "plot: path: bad number at offset 6"},
+		{"M1 2 3", "plot: path: bad number at offset 6"},
+		{"M1 2 X3", `plot: path: unknown command 'X'`},
+		{"M1 2 Z 3", "plot: path: path must start with a command"},
+		{"M1e 2", "plot: path: bad number at offset 1"},
+		{"M0 0 A1 1 0 2 0 1 1", "plot: path: bad flag at offset 12"},
+		{"M0 0 A1 1 0 0 5 1 1", "plot: path: bad flag at offset 14"},
+		{"M0 0 A1 1 0 0", "plot: path: bad flag at offset 13"},
+	} {
+		_, err := ParsePath(tt.d)
+		if err == nil || err.Error() != tt.msg {
+			t.Errorf("%q: %v, want %s", tt.d, err, tt.msg)
+		}
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	p, err := ParsePath("M0 0 C0 10 10 10 10 0 S20 -10 20 0 Q25 10 30 0 A5 5 0 1 1 40 0 M0 20 L10 20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := 0
+	for _, tol := range []float64{1, 0.1, 0.01, 0.001} {
+		ls := p.Flatten(tol)
+		if len(ls) != 2 {
+			t.Fatalf("tolerance %v: %d polylines, want 2", tol, len(ls))
+		}
+		// A line is not split
+		if len(ls[1]) != 2 {
+			t.Errorf("tolerance %v: line flattened to %v", tol, ls[1])
+		}
+		if n := len(ls[0]); n <= prev {
+			t.Errorf("tolerance %v: %d points, no more than %d at a looser tolerance", tol, n, prev)
+		} else {
+			prev = n
+		}
+
+		// Every point of the curves is within tol of the polyline
+		l := ls[0]
+		for i, c := range p.subs[0].segs {
+			for k := 0; k <= 100; k++ {
+				q := c.at(float64(k) / 100)
+				d := math.Inf(1)
+				for j := 1; j < len(l); j++ {
+					d = math.Min(d, segDist(q, l[j-1], l[j]))
+				}
+				if d > tol*(1+1e-9) {
+					t.Errorf("tolerance %v: segment %d at %v is %v from the polyline", tol, i, q, d)
+				}
+			}
+		}
+	}
+
+	// Subpaths with no segments are dropped
+	p, err = ParsePath("M0 0 M1 1 L2 2 M3 3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ls := p.Flatten(0.1); len(ls) != 1 || len(ls[0]) != 2 {
+		t.Errorf("Flatten = %v, want one line", ls)
+	}
+}
+
+// segDist returns the distance from q to the segment a, b.
+func segDist(q, a, b Point) float64 {
+	dx, dy := b.X-a.X, b.Y-a.Y
+	t := 0.0
+	if l := dx*dx + dy*dy; l > 0 {
+		t = math.Max(0, math.Min(1, ((q.X-a.X)*dx+(q.Y-a.Y)*dy)/l))
+	}
+	return math.Hypot(q.X-a.X-t*dx, q.Y-a.Y-t*dy)
+}
@@ -0,0 +1,177 @@
+// Package plot turns SVG drawings into pen plotter trajectories.
+package plot
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"math"
+
+	"github.com/afking/godelta/trajectory"
+)
+
+// Parse reads the path elements of an SVG document. Transforms and other
+// shape elements are ignored.
+func Parse(r io.Reader) ([]*Path, error) {
+	var paths []*Path
+	dec := xml.NewDecoder(r)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return paths, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		el, ok := tok.(xml.StartElement)
+		if !ok || el.Name.Local != "path" {
+			continue
+		}
+		for _, a := range el.Attr {
+			if a.Name.Local != "d" {
+				continue
+			}
+			p, err := ParsePath(a.Value)
+			if err != nil {
+				return nil, err
+			}
+			paths = append(paths, p)
+		}
+	}
+}
+
+// Region is a rectangle of the XY plane in metres.
+type Region struct {
+	MinX, MinY, MaxX, MaxY float64
+}
+
+var errEmpty = errors.New("plot: nothing to draw")
+
+// Fit flattens paths to within tol metres and scales them to fill r,
+// centred and keeping their aspect ratio. SVG y points down so the drawing
+// is flipped.
+func Fit(paths []*Path, r Region, tol float64) ([]Polyline, error) {
+	min := Point{math.Inf(1), math.Inf(1)}
+	max := Point{math.Inf(-1), math.Inf(-1)}
+	for _, p := range paths {
+		if lo, hi, ok := p.bounds(); ok {
+			min.X, min.Y = math.Min(min.X, lo.X), math.Min(min.Y, lo.Y)
+			max.X, max.Y = math.Max(max.X, hi.X), math.Max(max.Y, hi.Y)
+		}
+	}
+	scale := fitScale(min, max, r)
+	if scale == 0 {
+		return nil, errEmpty
+	}
+
+	// The control point box may be larger than the drawing, so flatten,
+	// measure and flatten again at the final scale.
+	var lines []Polyline
+	for pass := 0; pass < 2; pass++ {
+		lines = lines[:0]
+		for _, p := range paths {
+			lines = append(lines, p.Flatten(tol/scale)...)
+		}
+		min = Point{math.Inf(1), math.Inf(1)}
+		max = Point{math.Inf(-1), math.Inf(-1)}
+		for _, l := range lines {
+			for _, q := range l {
+				min.X, min.Y = math.Min(min.X, q.X), math.Min(min.Y, q.Y)
+				max.X, max.Y = math.Max(max.X, q.X), math.Max(max.Y, q.Y)
+			}
+		}
+		if scale = fitScale(min, max, r); scale == 0 {
+			return nil, errEmpty
+		}
+	}
+
+	cx, cy := (min.X+max.X)/2, (min.Y+max.Y)/2
+	rx, ry := (r.MinX+r.MaxX)/2, (r.MinY+r.MaxY)/2
+	for _, l := range lines {
+		for i, q := range l {
+			l[i] = Point{rx + (q.X-cx)*scale, ry - (q.Y-cy)*scale}
+		}
+	}
+	return lines, nil
+}
+
+// fitScale returns the scale fitting the box min, max inside r, or 0 if
+// the box is empty.
+func fitScale(min, max Point, r Region) float64 {
+	w, h := max.X-min.X, max.Y-min.Y
+	if math.IsInf(w, 0) || math.IsNaN(w) || (w <= 0 && h <= 0) {
+		return 0
+	}
+	s := math.Inf(1)
+	if w > 0 {
+		s = (r.MaxX - r.MinX) / w
+	}
+	if h > 0 {
+		s = math.Min(s, (r.MaxY-r.MinY)/h)
+	}
+	return s
+}
+
+// Pen heights, z in metres.
+type Pen struct {
+	Up, Down float64
+}
+
+// Toolpath returns a trajectory from the origin drawing each line with the
+// pen down, lifting it between lines that do not join, and returning to the
+// origin.
+func Toolpath(lines []Polyline, pen Pen, l trajectory.Limits) (*trajectory.Trajectory, error) {
+	var ts []*trajectory.Trajectory
+	cur := trajectory.Point{}
+	move := func(to trajectory.Point) error {
+		t, err := trajectory.New([]trajectory.Point{cur, to}, l)
+		if err != nil {
+			return err
+		}
+		ts = append(ts, t)
+		cur = to
+		return nil
+	}
+	lift := func() error {
+		return move(trajectory.Point{X: cur.X, Y: cur.Y, Z: pen.Up})
+	}
+
+	if err := lift(); err != nil {
+		return nil, err
+	}
+	for _, line := range lines {
+		start := trajectory.Point{X: line[0].X, Y: line[0].Y, Z: pen.Down}
+		if cur != start {
+			for _, to := range []trajectory.Point{
+				{X: cur.X, Y: cur.Y, Z: pen.Up},
+				{X: start.X, Y: start.Y, Z: pen.Up},
+				start,
+			} {
+				if err := move(to); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		path := make([]trajectory.Point, len(line))
+		for i, q := range line {
+			path[i] = trajectory.Point{X: q.X, Y: q.Y, Z: pen.Down}
+		}
+		t, err := trajectory.Corners(path, l, trajectory.MaxCorner)
+		if err != nil {
+			return nil, err
+		}
+		ts = append(ts, t)
+		cur = path[len(path)-1]
+	}
+	if err := lift(); err != nil {
+		return nil, err
+	}
+	if err := move(trajectory.Point{Z: pen.Up}); err != nil {
+		return nil, err
+	}
+	if err := move(trajectory.Point{}); err != nil {
+		return nil, err
+	}
+	return trajectory.Join(ts...), nil
+}
@@ -0,0 +1,191 @@
+package plot
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/afking/godelta/trajectory"
+)
+
+// mustPaths parses path data or fails the test.
+func mustPaths(t *testing.T, ds ...string) []*Path {
+	t.Helper()
+	var ps []*Path
+	for _, d := range ds {
+		p, err := ParsePath(d)
+		if err != nil {
+			t.Fatalf("%q: %v", d, err)
+		}
+		ps = append(ps, p)
+	}
+	return ps
+}
+
+func TestParse(t *testing.T) {
+	ps, err := Parse(strings.NewReader(`<?xml version="1.0"?>
+<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10">
+  <rect x="1" y="1" width="2" height="2"/>
+  <g><path d="M0 0 L1 1"/></g>
+  <path id="b" d="M2 2 L3 3 M4 4 L5 5"/>
+</svg>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps) != 2 || len(ps[0].subs) != 1 || len(ps[1].subs) != 2 {
+		t.Errorf("Parse = %v, want two paths", ps)
+	}
+
+	for _, doc := range []string{`<svg><path d="M0 0 X1"/></svg>`, `<svg><path d="M0 0"`} {
+		if _, err := Parse(strings.NewReader(doc)); err == nil {
+			t.Errorf("Parse(%q) succeeded", doc)
+		}
+	}
+}
+
+func TestFit(t *testing.T) {
+	r := Region{MinX: -0.05, MinY: 0, MaxX: 0.05, MaxY: 0.1}
+	for _, tt := range []struct {
+		name string
+		d    string
+		want []Point
+	}{
+		// Wider than tall: fills the width, centred in y, and y is
+		// flipped
+		{"wide", "M0 0 L20 0 L20 10", []Point{{-0.05, 0.075}, {0.05, 0.075}, {0.05, 0.025}}},
+		// Taller than wide: fills the height, centred in x
+		{"tall", "M10 10 L10 30 L20 30", []Point{{-0.025, 0.1}, {-0.025, 0}, {0.025, 0}}},
+		{"horizontal", "M5 5 L7 5", []Point{{-0.05, 0.05}, {0.05, 0.05}}},
+		{"vertical", "M5 5 L5 7", []Point{{0, 0.1}, {0, 0}}},
+	} {
+		lines, err := Fit(mustPaths(t, tt.d), r, 1e-4)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		ok := len(lines) == 1 && len(lines[0]) == len(tt.want)
+		for i := 0; ok && i < len(tt.want); i++ {
+			ok = near(lines[0][i], tt.want[i])
+		}
+		if !ok {
+			t.Errorf("%s: Fit = %v, want %v", tt.name, lines, tt.want)
+		}
+	}
+
+	// The drawing fills the region, not its control points, and is
+	// flattened to the tolerance in metres
+	const tol = 1e-4
+	ps := mustPaths(t, "M0 0 C0 -30 10 -30 10 0", "M0 10 L10 10")
+	lines, err := Fit(ps, r, tol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	min := Point{math.Inf(1), math.Inf(1)}
+	max := Point{math.Inf(-1), math.Inf(-1)}
+	for _, l := range lines {
+		for _, q := range l {
+			min.X, min.Y = math.Min(min.X, q.X), math.Min(min.Y, q.Y)
+			max.X, max.Y = math.Max(max.X, q.X), math.Max(max.Y, q.Y)
+		}
+	}
+	if math.Abs(min.Y-r.MinY) > 1e-12 || math.Abs(max.Y-r.MaxY) > 1e-12 {
+		t.Errorf("drawing spans y %v to %v, want %v to %v", min.Y, max.Y, r.MinY, r.MaxY)
+	}
+	if w := max.X - min.X; math.Abs((min.X+max.X)/2) > 1e-12 || w > r.MaxX-r.MinX {
+		t.Errorf("drawing spans x %v to %v, want centred inside the region", min.X, max.X)
+	}
+	// The curve bulges up in SVG, so down here: 22.5 of its 30 unit
+	// height is drawn
+	scale := (r.MaxY - r.MinY) / 32.5
+	if want := 10 * scale; math.Abs(max.X-min.X-want) > 1e-12 {
+		t.Errorf("drawing is %v wide, want %v", max.X-min.X, want)
+	}
+	if n := len(lines[0]); n < 10 {
+		t.Errorf("curve flattened to %d points", n)
+	}
+
+	for _, ds := range [][]string{nil, {""}, {"M1 1"}, {"M1 1 L1 1"}} {
+		if _, err := Fit(mustPaths(t, ds...), r, tol); err != errEmpty {
+			t.Errorf("Fit(%q) = %v, want %v", ds, err, errEmpty)
+		}
+	}
+}
+
+func TestToolpath(t *testing.T) {
+	limits := trajectory.Limits{Velocity: 0.1, Acceleration: 0.5, Jerk: 5}
+	pen := Pen{Up: -0.01, Down: -0.02}
+	lines := []Polyline{
+		{{0.01, 0}, {0.02, 0}},
+		{{0.02, 0}, {0.02, 0.01}}, // joins the first
+		{{0, 0.01}, {0, 0.02}, {-0.01, 0.02}},
+	}
+	tr, err := Toolpath(lines, pen, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := tr.At(0); p != (trajectory.Point{}) {
+		t.Errorf("starts at %v, want the origin", p)
+	}
+	if p := tr.End(); p != (trajectory.Point{}) {
+		t.Errorf("ends at %v, want the origin", p)
+	}
+
+	const eps = 1e-9
+	onLine := func(p trajectory.Point) bool {
+		for _, l := range lines {
+			for i := 1; i < len(l); i++ {
+				if segDist(Point{p.X, p.Y}, l[i-1], l[i]) < eps {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	// Below the pen up height only on a line, and pen down twice
+	downs := 0
+	down := false
+	for _, p := range tr.Sample(time.Millisecond) {
+		if p.Z < pen.Up-eps && !onLine(p) {
+			t.Errorf("pen at %v, off the drawing", p)
+			break
+		}
+		if p.Z < pen.Down-eps {
+			t.Errorf("pen at %v, below %v", p, pen.Down)
+			break
+		}
+		if d := p.Z < pen.Down+eps; d != down {
+			down = d
+			if d {
+				downs++
+			}
+		}
+	}
+	if downs != 2 {
+		t.Errorf("pen down %d times, want 2", downs)
+	}
+
+	// Every corner is drawn, at rest
+	for _, l := range lines {
+		for _, q := range l {
+			want := trajectory.Point{X: q.X, Y: q.Y, Z: pen.Down}
+			found := false
+			for d := time.Duration(0); d <= tr.Duration() && !found; d += 100 * time.Microsecond {
+				p := tr.At(d)
+				found = math.Abs(p.X-want.X) < 1e-6 && math.Abs(p.Y-want.Y) < 1e-6 && math.Abs(p.Z-want.Z) < eps
+			}
+			if !found {
+				t.Errorf("never at %v", want)
+			}
+		}
+	}
+
+	if _, err := Toolpath(lines, pen, trajectory.Limits{}); err == nil {
+		t.Error("Toolpath with no limits succeeded")
+	}
+	empty, err := Toolpath(nil, pen, limits)
+	if err != nil || empty.End() != (trajectory.Point{}) {
+		t.Errorf("Toolpath(nil) = %v, %v, want a lift back to the origin", empty, err)
+	}
+}
@@ -0,0 +1,179 @@
+// Package record saves and loads timestamped sessions of messages sent to
+// and received from an arm.
+//
+// A session file starts with Magic. Each record is a direction byte, 'S' for
+// sent or 'R' for received, the time since the session started in
+// nanoseconds as a base 128 varint, then the message as a delta frame.
+package record
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/afking/godelta/delta"
+)
+
+// Magic starts every session file.
+const Magic = "DELTASESSION1\n"
+
+// Direction of a recorded message.
+type Direction byte
+
+const (
+	Sent     Direction = 'S'
+	Received Direction = 'R'
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Sent:
+		return "sent"
+	case Received:
+		return "received"
+	}
+	return fmt.Sprintf("Direction(%d)", byte(d))
+}
+
+// Frame is a recorded message.
+type Frame struct {
+	Time time.Duration // since the session started
+	Dir  Direction
+	Msg  *delta.Message
+}
+
+// ErrFormat is returned for files that are not sessions.
+var ErrFormat = errors.New("record: not a session file")
+
+// Writer records a session. It is safe for concurrent use.
+type Writer struct {
+	mu    sync.Mutex
+	w     *bufio.Writer
+	fw    *delta.Writer
+	c     io.Closer
+	start time.Time
+	err   error // first write error
+}
+
+// Create records a session to a new file at path.
+func Create(path string) (*Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := NewWriter(f)
+	w.c = f
+	return w, nil
+}
+
+// NewWriter records a session to w, starting now.
+func NewWriter(w io.Writer) *Writer {
+	bw := bufio.NewWriter(w)
+	rw := &Writer{
+		w:     bw,
+		fw:    delta.NewWriterSize(bw, 1<<16),
+		start: time.Now(),
+	}
+	_, rw.err = bw.WriteString(Magic)
+	return rw
+}
+
+// Trace records msg now. Its signature matches client.Dialer.Trace; errors
+// are kept for Close.
+func (w *Writer) Trace(sent bool, msg *delta.Message) {
+	d := Received
+	if sent {
+		d = Sent
+	}
+	w.Write(Frame{Time: time.Since(w.start), Dir: d, Msg: msg})
+}
+
+// Write records a frame.
+func (w *Writer) Write(f Frame) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+
+	var hdr [1 + binary.MaxVarintLen64]byte
+	hdr[0] = byte(f.Dir)
+	n := 1 + binary.PutUvarint(hdr[1:], uint64(f.Time))
+	if _, w.err = w.w.Write(hdr[:n]); w.err != nil {
+		return w.err
+	}
+	w.err = w.fw.Write(f.Msg)
+	return w.err
+}
+
+// Close flushes the session and closes the file, returning the first error
+// met while recording.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.w.Flush(); w.err == nil {
+		w.err = err
+	}
+	if w.c != nil {
+		if err := w.c.Close(); w.err == nil {
+			w.err = err
+		}
+	}
+	return w.err
+}
+
+// Reader reads a recorded session.
+type Reader struct {
+	r  *bufio.Reader
+	fr *delta.Reader
+}
+
+// NewReader checks the session header and returns a Reader for the frames.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(Magic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != Magic {
+		return nil, ErrFormat
+	}
+	return &Reader{
+		r:  br,
+		fr: delta.NewReaderSize(br, 1<<16),
+	}, nil
+}
+
+// Read returns the next frame, or io.EOF at the end of the session. A
+// *delta.DecodeError leaves the reader in sync for the next frame.
+func (r *Reader) Read() (Frame, error) {
+	var f Frame
+	d, err := r.r.ReadByte()
+	if err != nil {
+		return f, err
+	}
+	if d != byte(Sent) && d != byte(Received) {
+		return f, fmt.Errorf("record: bad direction %q", d)
+	}
+	f.Dir = Direction(d)
+
+	t, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return f, err
+	}
+	f.Time = time.Duration(t)
+
+	f.Msg = &delta.Message{}
+	if err := r.fr.Read(f.Msg); err != nil {
+		if err == io.EOF {
+			err = &delta.TruncatedFrameError{Size: -1}
+		}
+		return f, err
+	}
+	return f, nil
+}
@@ -0,0 +1,197 @@
+package record
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/afking/godelta/delta"
+	"github.com/golang/protobuf/proto"
+)
+
+var frames = []Frame{
+	{0, Sent, &delta.Message{Type: delta.Message_PING.Enum(), Id: proto.Uint32(1)}},
+	{time.Millisecond, Received, &delta.Message{Type: delta.Message_PING.Enum(), Id: proto.Uint32(1)}},
+	{time.Second, Sent, &delta.Message{Type: delta.Message_POINT.Enum(), Point: &delta.Point{X: proto.Float64(0.01), Y: proto.Float64(-0.02), Z: proto.Float64(-0.1)}}},
+	// Longer than the reader's buffer
+	{time.Hour, Received, &delta.Message{Type: delta.Message_ERROR.Enum(), Info: proto.String(strings.Repeat("x", 10000))}},
+	{time.Hour + 1, Sent, &delta.Message{Type: delta.Message_STOP.Enum()}},
+}
+
+// session returns frames recorded to a buffer.
+func session(t *testing.T, frames []Frame) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	w := NewWriter(&b)
+	for _, f := range frames {
+		if err := w.Write(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// readAll reads frames until an error, which it returns.
+func readAll(r *Reader) ([]Frame, error) {
+	var fs []Frame
+	for {
+		f, err := r.Read()
+		if err != nil {
+			return fs, err
+		}
+		fs = append(fs, f)
+	}
+}
+
+func sameFrames(got, want []Frame) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i].Time != want[i].Time || got[i].Dir != want[i].Dir || !proto.Equal(got[i].Msg, want[i].Msg) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRoundTrip(t *testing.T) {
+	data := session(t, frames)
+	if !bytes.HasPrefix(data, []byte(Magic)) {
+		t.Errorf("session starts %q, want %q", data[:len(Magic)], Magic)
+	}
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := readAll(r)
+	if err != io.EOF {
+		t.Errorf("Read at end = %v, want io.EOF", err)
+	}
+	if !sameFrames(got, frames) {
+		t.Errorf("read %v, want %v", got, frames)
+	}
+
+	// An empty session is only the header
+	r, err = NewReader(bytes.NewReader(session(t, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Read(); err != io.EOF {
+		t.Errorf("Read of an empty session = %v, want io.EOF", err)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session")
+	w, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ping := &delta.Message{Type: delta.Message_PING.Enum()}
+	w.Trace(true, ping)
+	w.Trace(false, ping)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := readAll(r)
+	if err != io.EOF || len(got) != 2 {
+		t.Fatalf("read %v, %v, want two frames", got, err)
+	}
+	if got[0].Dir != Sent || got[1].Dir != Received || got[1].Time < got[0].Time || !proto.Equal(got[1].Msg, ping) {
+		t.Errorf("read %v, want a ping sent then received", got)
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	data := session(t, frames)
+	full := len(session(t, frames[:len(frames)-1]))
+
+	// Cut the last record anywhere: the others read back, then an
+	// unexpected end, then EOF
+	for n := full + 1; n < len(data); n++ {
+		r, err := NewReader(bytes.NewReader(data[:n]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := readAll(r)
+		var terr *delta.TruncatedFrameError
+		if err != io.ErrUnexpectedEOF && !errors.As(err, &terr) {
+			t.Errorf("cut at %d: Read = %v, want a truncation error", n, err)
+		}
+		if !sameFrames(got, frames[:len(frames)-1]) {
+			t.Errorf("cut at %d: read %d frames, want the %d complete ones", n, len(got), len(frames)-1)
+		}
+		if _, err := r.Read(); err != io.EOF {
+			t.Errorf("cut at %d: Read after the truncation = %v, want io.EOF", n, err)
+		}
+	}
+
+	// Cut in a long message
+	r, err := NewReader(bytes.NewReader(session(t, frames[:4])[:full-100]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := readAll(r)
+	var terr *delta.TruncatedFrameError
+	if !errors.As(err, &terr) || len(got) != 3 {
+		t.Errorf("read %d frames then %v, want 3 then a truncated frame", len(got), err)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	for _, data := range []string{"", "DELTA", "DELTASESSION2\n", "\x05\x08\x01"} {
+		if _, err := NewReader(strings.NewReader(data)); err != ErrFormat {
+			t.Errorf("NewReader(%q) = %v, want ErrFormat", data, err)
+		}
+	}
+
+	r, err := NewReader(strings.NewReader(Magic + "X\x00\x02\x08\x01"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Read(); err == nil || err.Error() != `record: bad direction 'X'` {
+		t.Errorf("Read of a bad direction = %v", err)
+	}
+
+	for d, want := range map[Direction]string{Sent: "sent", Received: "received", 'x': "Direction(120)"} {
+		if got := d.String(); got != want {
+			t.Errorf("%q.String() = %q, want %q", byte(d), got, want)
+		}
+	}
+}
+
+// failWriter fails every write.
+type failWriter struct{}
+
+func (failWriter) Write([]byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestWriteError(t *testing.T) {
+	w := NewWriter(failWriter{})
+	// Buffered, so the error shows at the flush and sticks
+	w.Trace(true, frames[3].Msg)
+	if err := w.Write(frames[0]); err == nil {
+		t.Error("Write after a failed flush succeeded")
+	}
+	if err := w.Close(); err == nil || err.Error() != "disk full" {
+		t.Errorf("Close = %v, want the write error", err)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/afking/godelta/delta"
+	"github.com/afking/godelta/record"
+	"github.com/codegangsta/cli"
+	"github.com/golang/protobuf/proto"
+)
+
+// replay resends the messages sent in a session file, with their original
+// timing scaled by --speed, or one at a time with --step. Heartbeats are not
+// resent as replay sends its own, and IDs are dropped so stale replies are
+// not waited for.
+func replay(c *cli.Context) error {
+	if len(c.Args()) != 1 {
+		return fmt.Errorf("usage: delta replay [--speed n] [--step] session.log")
+	}
+	speed := c.Float64("speed")
+	if speed <= 0 {
+		return fmt.Errorf("speed must be positive")
+	}
+	step := c.Bool("step")
+
+	f, err := os.Open(c.Args().First())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := record.NewReader(f)
+	if err != nil {
+		return err
+	}
+
+	var lines chan struct{}
+	if step {
+		lines = make(chan struct{})
+		go func() {
+			sc := bufio.NewScanner(os.Stdin)
+			for sc.Scan() {
+				lines <- struct{}{}
+			}
+			close(lines)
+		}()
+	}
+
+	sent, total := 0, 0
+	start := time.Now()
+	for {
+		fr, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		total++
+		if fr.Dir != record.Sent || fr.Msg.GetType() == delta.Message_HEARTBEAT {
+			continue
+		}
+
+		fr.Msg.Id = nil
+		if step {
+			fmt.Printf("%10v %s %s [enter]", fr.Time, fr.Msg.GetType(), proto.CompactTextString(fr.Msg))
+			if err := waitLine(cmdCtx, lines); err == io.EOF {
+				log.Println("replay: end of input")
+				break
+			} else if err != nil {
+				return err
+			}
+		} else {
+			at := start.Add(time.Duration(float64(fr.Time) / speed))
+			if err := waitUntil(cmdCtx, at); err != nil {
+				return err
+			}
+		}
+
+		if err := arm.Send(cmdCtx, fr.Msg); err != nil {
+			log.Printf("replay: %s: %v", fr.Msg.GetType(), err)
+			continue
+		}
+		sent++
+	}
+	log.Printf("replay: sent %d of %d frames in %v", sent, total, time.Since(start))
+	return nil
+}
+
+// waitUntil waits for t, beating the heart while the replay is on time
+func waitUntil(ctx context.Context, t time.Time) error {
+	for {
+		heart.Beat()
+		d := time.Until(t)
+		if d <= 0 {
+			return nil
+		}
+		if d > cfg.Heartbeat && cfg.Heartbeat > 0 {
+			d = cfg.Heartbeat
+		}
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// waitLine waits for a line on stdin, beating the heart as the user is
+// in control
+func waitLine(ctx context.Context, lines <-chan struct{}) error {
+	period := cfg.Watchdog / 2
+	if period <= 0 {
+		period = time.Second
+	}
+	tick := time.NewTicker(period)
+	defer tick.Stop()
+	for {
+		heart.Beat()
+		select {
+		case _, ok := <-lines:
+			if !ok {
+				return io.EOF
+			}
+			return nil
+		case <-tick.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,138 @@
+// Package safety stops the arm when the client fails.
+//
+// An EStop sends STOP once, on a signal, a panic or a call to Trigger. A
+// Heart sends HEARTBEAT messages while a control loop keeps beating it, so
+// firmware watching them stops the arm if the client hangs or dies.
+package safety
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// EStop stops the arm once.
+type EStop struct {
+	stop  func() error
+	once  sync.Once
+	fired int32
+}
+
+// NewEStop returns an EStop calling stop when triggered.
+func NewEStop(stop func() error) *EStop {
+	return &EStop{stop: stop}
+}
+
+// Trigger stops the arm, logging why. Only the first call sends STOP.
+func (e *EStop) Trigger(reason string) {
+	e.once.Do(func() {
+		atomic.StoreInt32(&e.fired, 1)
+		log.Println("e-stop:", reason)
+		if err := e.stop(); err != nil {
+			log.Println("e-stop:", err)
+		}
+	})
+}
+
+// Triggered reports whether the arm has been stopped. It stays stopped for
+// the life of the EStop.
+func (e *EStop) Triggered() bool {
+	return atomic.LoadInt32(&e.fired) != 0
+}
+
+// Notify triggers on SIGINT or SIGTERM until the returned function is
+// called. Only the first signal is caught, so another one still exits.
+func (e *EStop) Notify() (stop func()) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case s := <-sig:
+			signal.Stop(sig)
+			e.Trigger(s.String())
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(sig)
+		close(done)
+	}
+}
+
+// Recover triggers on a panic, then panics again. Call it with defer.
+func (e *EStop) Recover() {
+	if r := recover(); r != nil {
+		e.Trigger(fmt.Sprint("panic: ", r))
+		panic(r)
+	}
+}
+
+// Heart sends heartbeats while it is beaten.
+type Heart struct {
+	interval, watchdog time.Duration
+	send               func(watchdog time.Duration) error
+	last               int64 // unix nanoseconds of the last Beat
+}
+
+// NewHeart returns a Heart sending a heartbeat every interval with the given
+// watchdog, which should be a few intervals. A zero interval disables it.
+func NewHeart(interval, watchdog time.Duration, send func(watchdog time.Duration) error) *Heart {
+	return &Heart{
+		interval: interval,
+		watchdog: watchdog,
+		send:     send,
+	}
+}
+
+// Beat shows the control loop is alive. Nothing is sent until the first
+// beat, so the watchdog is not armed by commands that never move the arm.
+func (h *Heart) Beat() {
+	atomic.StoreInt64(&h.last, time.Now().UnixNano())
+}
+
+// Run sends heartbeats until ctx is done, then disarms the watchdog if it
+// was armed. Heartbeats stop while the loop has not beaten within the
+// watchdog, letting the arm stop itself.
+func (h *Heart) Run(ctx context.Context) {
+	if h.interval <= 0 {
+		return
+	}
+	tick := time.NewTicker(h.interval)
+	defer tick.Stop()
+
+	armed, stalled := false, false
+	for {
+		select {
+		case <-ctx.Done():
+			if armed {
+				h.send(0)
+			}
+			return
+		case <-tick.C:
+		}
+
+		last := atomic.LoadInt64(&h.last)
+		if last == 0 {
+			continue
+		}
+		if time.Since(time.Unix(0, last)) > h.watchdog {
+			if !stalled {
+				log.Println("heartbeat: control loop stalled")
+				stalled = true
+			}
+			continue
+		}
+		stalled = false
+		if err := h.send(h.watchdog); err != nil {
+			continue // the arm's watchdog covers a lost connection
+		}
+		armed = true
+	}
+}
@@ -0,0 +1,174 @@
+package safety
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEStopOnce(t *testing.T) {
+	var mu sync.Mutex
+	stops := 0
+	e := NewEStop(func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		stops++
+		return errors.New("not connected") // logged, not retried
+	})
+	if e.Triggered() {
+		t.Fatal("Triggered before Trigger")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e.Trigger("test")
+		}()
+	}
+	wg.Wait()
+	e.Trigger("again")
+	if stops != 1 {
+		t.Errorf("stop called %d times, want once", stops)
+	}
+	if !e.Triggered() {
+		t.Error("not Triggered after Trigger")
+	}
+}
+
+func TestEStopRecover(t *testing.T) {
+	stops := 0
+	e := NewEStop(func() error { stops++; return nil })
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Errorf("recovered %v, want the panic passed on", r)
+			}
+		}()
+		defer e.Recover()
+		panic("boom")
+	}()
+	if stops != 1 || !e.Triggered() {
+		t.Errorf("stop called %d times, Triggered %v, want once after a panic", stops, e.Triggered())
+	}
+
+	// No panic, no stop
+	e = NewEStop(func() error { stops++; return nil })
+	func() { defer e.Recover() }()
+	if e.Triggered() {
+		t.Error("Triggered without a panic")
+	}
+}
+
+// beats records the watchdogs a Heart sends.
+type beats struct {
+	mu   sync.Mutex
+	sent []time.Duration
+}
+
+func (b *beats) send(watchdog time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sent = append(b.sent, watchdog)
+	return nil
+}
+
+func (b *beats) get() []time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]time.Duration(nil), b.sent...)
+}
+
+// waitFor polls cond until it holds, failing the test after a second.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !cond(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+func TestHeart(t *testing.T) {
+	const (
+		interval = 5 * time.Millisecond
+		watchdog = 30 * time.Millisecond
+	)
+	var b beats
+	h := NewHeart(interval, watchdog, b.send)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		h.Run(ctx)
+		close(done)
+	}()
+
+	// Nothing is sent before the first beat
+	time.Sleep(4 * interval)
+	if n := len(b.get()); n != 0 {
+		t.Fatalf("%d heartbeats before the first beat", n)
+	}
+
+	h.Beat()
+	waitFor(t, "heartbeats", func() bool { return len(b.get()) >= 2 })
+	for _, w := range b.get() {
+		if w != watchdog {
+			t.Fatalf("sent watchdog %v, want %v", w, watchdog)
+		}
+	}
+
+	// With no beats for a watchdog, heartbeats stop
+	time.Sleep(watchdog + 50*time.Millisecond)
+	n := len(b.get())
+	time.Sleep(4 * interval)
+	if m := len(b.get()); m != n {
+		t.Errorf("%d heartbeats after the loop stalled", m-n)
+	}
+
+	// and start again with the loop
+	h.Beat()
+	waitFor(t, "heartbeats after a stall", func() bool { return len(b.get()) > n })
+
+	// Cancelling disarms the watchdog and stops the heart
+	cancel()
+	<-done
+	sent := b.get()
+	if last := sent[len(sent)-1]; last != 0 {
+		t.Errorf("last heartbeat watchdog %v, want 0 to disarm", last)
+	}
+	h.Beat()
+	time.Sleep(4 * interval)
+	if m := len(b.get()); m != len(sent) {
+		t.Errorf("%d heartbeats after Run returned", m-len(sent))
+	}
+}
+
+func TestHeartNeverArmed(t *testing.T) {
+	var b beats
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		NewHeart(time.Millisecond, 10*time.Millisecond, b.send).Run(ctx)
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	<-done
+	// Not beaten, so not armed, so not disarmed either
+	if sent := b.get(); len(sent) != 0 {
+		t.Errorf("sent %v without a beat", sent)
+	}
+
+	// A zero interval disables the heart
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	h := NewHeart(0, time.Second, b.send)
+	h.Beat()
+	h.Run(ctx) // returns at once
+	if sent := b.get(); len(sent) != 0 {
+		t.Errorf("disabled heart sent %v", sent)
+	}
+}
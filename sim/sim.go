@@ -0,0 +1,287 @@
+// Package sim simulates a delta arm speaking the delta.Message protocol.
+package sim
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/afking/godelta/delta"
+	"github.com/golang/protobuf/proto"
+)
+
+// Motors is the number of motors on the arm, numbered from 1.
+const Motors = 3
+
+// Server is a simulated arm. All connections share the same arm state.
+type Server struct {
+	mu      sync.Mutex
+	started bool
+	point   [3]float64
+	motors  map[int32]*delta.Motor
+
+	watchdog *time.Timer // armed by HEARTBEAT
+	beats    int         // heartbeats received, to spot stale timers
+	trips    int
+
+	cmu   sync.Mutex
+	lis   map[net.Listener]struct{}
+	conns map[net.Conn]struct{}
+}
+
+// New returns a stopped arm at the origin with default motor settings.
+func New() *Server {
+	s := &Server{
+		motors: make(map[int32]*delta.Motor),
+		lis:    make(map[net.Listener]struct{}),
+		conns:  make(map[net.Conn]struct{}),
+	}
+	for id := int32(1); id <= Motors; id++ {
+		s.motors[id] = &delta.Motor{
+			Id:       proto.Int32(id),
+			P:        proto.Int32(32),
+			I:        proto.Int32(0),
+			D:        proto.Int32(0),
+			Position: proto.Int32(0),
+			Velocity: proto.Int32(0),
+			Torque:   proto.Int32(1023),
+			Punch:    proto.Int32(32),
+		}
+	}
+	return s
+}
+
+// ListenAndServe listens on the TCP address addr and serves connections.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l until it is closed.
+func (s *Server) Serve(l net.Listener) error {
+	s.cmu.Lock()
+	s.lis[l] = struct{}{}
+	s.cmu.Unlock()
+	defer func() {
+		s.cmu.Lock()
+		delete(s.lis, l)
+		s.cmu.Unlock()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops all listeners and closes open connections.
+func (s *Server) Close() error {
+	s.cmu.Lock()
+	defer s.cmu.Unlock()
+
+	var err error
+	for l := range s.lis {
+		if cerr := l.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	return err
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	s.cmu.Lock()
+	s.conns[conn] = struct{}{}
+	s.cmu.Unlock()
+	defer func() {
+		s.cmu.Lock()
+		delete(s.conns, conn)
+		s.cmu.Unlock()
+		conn.Close()
+	}()
+
+	rd := delta.NewReader(conn)
+	wr := delta.NewWriter(conn)
+	for {
+		msg := &delta.Message{}
+		var rsp *delta.Message
+		if err := rd.Read(msg); err != nil {
+			var derr *delta.DecodeError
+			if !errors.As(err, &derr) {
+				if err != io.EOF {
+					log.Println("sim: ", err)
+				}
+				return
+			}
+			rsp = errMsg("%v", err)
+			rsp.Id = msg.Id
+		} else {
+			rsp = s.Handle(msg)
+		}
+		if rsp == nil {
+			continue
+		}
+		if err := wr.Write(rsp); err != nil {
+			log.Println("sim: ", err)
+			return
+		}
+	}
+}
+
+func errMsg(format string, a ...interface{}) *delta.Message {
+	return &delta.Message{
+		Type: delta.Message_ERROR.Enum(),
+		Info: proto.String(fmt.Sprintf(format, a...)),
+	}
+}
+
+// Handle applies msg to the arm and returns the reply, if any. Messages with
+// an ID are always answered.
+func (s *Server) Handle(msg *delta.Message) *delta.Message {
+	rsp, status := s.apply(msg)
+	if msg.Id == nil {
+		return rsp
+	}
+	if rsp == nil {
+		rsp = &delta.Message{Type: msg.Type}
+	}
+	rsp.Id = msg.Id
+	rsp.Status = status.Enum()
+	return rsp
+}
+
+func (s *Server) apply(msg *delta.Message) (*delta.Message, delta.Message_Status) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch msg.GetType() {
+	case delta.Message_PING:
+		return &delta.Message{
+			Type: delta.Message_PING.Enum(),
+			Info: msg.Info,
+		}, delta.Message_OK
+	case delta.Message_START:
+		s.started = true
+		return nil, delta.Message_OK
+	case delta.Message_STOP:
+		s.started = false
+		return nil, delta.Message_OK
+	case delta.Message_HEARTBEAT:
+		s.beats++
+		if s.watchdog != nil {
+			s.watchdog.Stop()
+			s.watchdog = nil
+		}
+		if d := time.Duration(msg.GetWatchdog()) * time.Millisecond; d > 0 {
+			beat := s.beats
+			s.watchdog = time.AfterFunc(d, func() { s.expire(beat) })
+		}
+		return nil, delta.Message_OK
+	case delta.Message_POINT:
+		p := msg.GetPoint()
+		if p == nil || p.X == nil || p.Y == nil || p.Z == nil {
+			return errMsg("POINT: missing coordinates"), delta.Message_INVALID
+		}
+		for _, v := range []float64{p.GetX(), p.GetY(), p.GetZ()} {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return errMsg("POINT: invalid coordinate %v", v), delta.Message_INVALID
+			}
+		}
+		if !s.started {
+			return nil, delta.Message_IGNORED // until START
+		}
+		s.point = [3]float64{p.GetX(), p.GetY(), p.GetZ()}
+		return nil, delta.Message_OK
+	case delta.Message_SET:
+		m, rsp := s.motor("SET", msg)
+		if rsp != nil {
+			return rsp, delta.Message_INVALID
+		}
+		proto.Merge(m, msg.GetMotor())
+		return nil, delta.Message_OK
+	case delta.Message_GET:
+		m, rsp := s.motor("GET", msg)
+		if rsp != nil {
+			return rsp, delta.Message_INVALID
+		}
+		return &delta.Message{
+			Type:  delta.Message_GET.Enum(),
+			Motor: proto.Clone(m).(*delta.Motor),
+		}, delta.Message_OK
+	}
+	return errMsg("unknown message type %d", msg.GetType()), delta.Message_INVALID
+}
+
+// expire stops the arm if no heartbeat followed beat.
+func (s *Server) expire(beat int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if beat != s.beats {
+		return
+	}
+	s.watchdog = nil
+	if s.started {
+		s.started = false
+		s.trips++
+		log.Println("sim: watchdog expired, stopping")
+	}
+}
+
+// motor looks up the motor addressed by msg, returning an ERROR reply if it
+// is invalid.
+func (s *Server) motor(op string, msg *delta.Message) (*delta.Motor, *delta.Message) {
+	if msg.GetMotor() == nil || msg.GetMotor().Id == nil {
+		return nil, errMsg("%s: missing motor id", op)
+	}
+	id := msg.GetMotor().GetId()
+	m, ok := s.motors[id]
+	if !ok {
+		return nil, errMsg("%s: unknown motor %d", op, id)
+	}
+	return m, nil
+}
+
+// Started reports whether the arm accepts POINT commands.
+func (s *Server) Started() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.started
+}
+
+// Trips returns how many times the heartbeat watchdog stopped the arm.
+func (s *Server) Trips() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.trips
+}
+
+// Point returns the simulated end-effector position.
+func (s *Server) Point() (x, y, z float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.point[0], s.point[1], s.point[2]
+}
+
+// Motor returns a copy of the state of motor id, or nil if there is none.
+func (s *Server) Motor(id int32) *delta.Motor {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.motors[id]
+	if !ok {
+		return nil
+	}
+	return proto.Clone(m).(*delta.Motor)
+}
@@ -0,0 +1,121 @@
+package sim
+
+import (
+	"testing"
+	"time"
+
+	"github.com/afking/godelta/delta"
+	"github.com/golang/protobuf/proto"
+)
+
+func msg(t delta.Message_Type) *delta.Message {
+	return &delta.Message{Type: t.Enum()}
+}
+
+func heartbeat(ms uint32) *delta.Message {
+	m := msg(delta.Message_HEARTBEAT)
+	m.Watchdog = proto.Uint32(ms)
+	return m
+}
+
+func point(x, y, z float64) *delta.Message {
+	m := msg(delta.Message_POINT)
+	m.Point = &delta.Point{X: &x, Y: &y, Z: &z}
+	return m
+}
+
+// waitFor polls cond until it holds or a second has passed.
+func waitFor(cond func() bool) bool {
+	for end := time.Now().Add(time.Second); time.Now().Before(end); time.Sleep(time.Millisecond) {
+		if cond() {
+			return true
+		}
+	}
+	return cond()
+}
+
+func TestWatchdogTrip(t *testing.T) {
+	s := New()
+	s.Handle(msg(delta.Message_START))
+	s.Handle(heartbeat(20))
+	if !s.Started() {
+		t.Fatal("arm not started")
+	}
+	if !waitFor(func() bool { return !s.Started() }) {
+		t.Fatal("watchdog did not stop the arm")
+	}
+	if n := s.Trips(); n != 1 {
+		t.Errorf("Trips = %d, want 1", n)
+	}
+
+	// Stopped by the watchdog, so POINTs are ignored until START
+	p := point(0.01, 0, 0)
+	p.Id = proto.Uint32(1)
+	if rsp := s.Handle(p); rsp.GetStatus() != delta.Message_IGNORED {
+		t.Errorf("POINT after trip = %v, want IGNORED", rsp)
+	}
+}
+
+func TestWatchdogFed(t *testing.T) {
+	s := New()
+	s.Handle(msg(delta.Message_START))
+	for i := 0; i < 10; i++ {
+		s.Handle(heartbeat(50))
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !s.Started() || s.Trips() != 0 {
+		t.Errorf("arm stopped while heartbeats kept coming, trips %d", s.Trips())
+	}
+
+	// A zero watchdog disarms it
+	s.Handle(heartbeat(0))
+	time.Sleep(100 * time.Millisecond)
+	if !s.Started() || s.Trips() != 0 {
+		t.Errorf("arm stopped after the watchdog was disarmed, trips %d", s.Trips())
+	}
+}
+
+func TestWatchdogStopped(t *testing.T) {
+	s := New()
+	s.Handle(heartbeat(10))
+	time.Sleep(50 * time.Millisecond)
+	if n := s.Trips(); n != 0 {
+		t.Errorf("Trips = %d on an arm that was not started, want 0", n)
+	}
+}
+
+func TestHandle(t *testing.T) {
+	s := New()
+	for _, tt := range []struct {
+		msg    *delta.Message
+		status delta.Message_Status
+	}{
+		{point(0.01, 0, 0), delta.Message_IGNORED},
+		{msg(delta.Message_START), delta.Message_OK},
+		{point(0.01, 0.02, 0.03), delta.Message_OK},
+		{&delta.Message{Type: delta.Message_POINT.Enum(), Point: &delta.Point{X: proto.Float64(0)}}, delta.Message_INVALID},
+		{&delta.Message{Type: delta.Message_GET.Enum(), Motor: &delta.Motor{Id: proto.Int32(4)}}, delta.Message_INVALID},
+		{&delta.Message{Type: delta.Message_SET.Enum(), Motor: &delta.Motor{Id: proto.Int32(1), P: proto.Int32(7)}}, delta.Message_OK},
+	} {
+		m := proto.Clone(tt.msg).(*delta.Message)
+		m.Id = proto.Uint32(42)
+		rsp := s.Handle(m)
+		if rsp.GetId() != 42 || rsp.GetStatus() != tt.status {
+			t.Errorf("Handle(%v) = %v, want id 42 status %v", tt.msg, rsp, tt.status)
+		}
+	}
+	if x, y, z := s.Point(); x != 0.01 || y != 0.02 || z != 0.03 {
+		t.Errorf("Point = (%v, %v, %v), want (0.01, 0.02, 0.03)", x, y, z)
+	}
+	if p := s.Motor(1).GetP(); p != 7 {
+		t.Errorf("motor 1 P = %d, want 7", p)
+	}
+
+	// Without an ID only replies with content are sent
+	if rsp := s.Handle(msg(delta.Message_STOP)); rsp != nil {
+		t.Errorf("Handle(STOP) = %v, want no reply", rsp)
+	}
+	if rsp := s.Handle(msg(delta.Message_PING)); rsp.GetType() != delta.Message_PING {
+		t.Errorf("Handle(PING) = %v, want PING", rsp)
+	}
+}
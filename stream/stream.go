@@ -0,0 +1,177 @@
+// Package stream sends setpoints to the arm at a fixed rate.
+package stream
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Stats describe how well a Streamer kept to its rate.
+type Stats struct {
+	Sent    uint64 // setpoints sent
+	Dropped uint64 // setpoints replaced before they were sent
+	Missed  uint64 // ticks skipped because the loop fell behind
+
+	MeanJitter, MaxJitter   time.Duration // tick lateness
+	MeanLatency, MaxLatency time.Duration // time spent in send
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("sent %d, dropped %d, missed %d, jitter %v (max %v), latency %v (max %v)",
+		s.Sent, s.Dropped, s.Missed, s.MeanJitter, s.MaxJitter, s.MeanLatency, s.MaxLatency)
+}
+
+// Streamer sends the latest setpoint on every tick. Setpoints are never
+// queued: a newer Set replaces one not yet sent.
+type Streamer struct {
+	period time.Duration
+	send   func(x, y, z float64) error
+	sample Sampler
+	stop   chan struct{}
+	once   sync.Once
+
+	mu    sync.Mutex
+	sp    [3]float64
+	have  bool // a setpoint has been set
+	fresh bool // sp has not been sent
+	stats Stats
+	ticks uint64
+	jsum  time.Duration
+	lsum  time.Duration
+}
+
+// Sampler returns the setpoint d after Run started, and whether it is the
+// last.
+type Sampler func(d time.Duration) (x, y, z float64, last bool)
+
+// New returns a Streamer calling send every period, which must be positive.
+func New(period time.Duration, send func(x, y, z float64) error) (*Streamer, error) {
+	if period <= 0 {
+		return nil, fmt.Errorf("stream: invalid period %v", period)
+	}
+	return &Streamer{
+		period: period,
+		send:   send,
+		stop:   make(chan struct{}),
+	}, nil
+}
+
+// NewSampler returns a Streamer that sets the setpoint from sample at every
+// tick before sending it, and stops after the last.
+func NewSampler(period time.Duration, sample Sampler, send func(x, y, z float64) error) (*Streamer, error) {
+	s, err := New(period, send)
+	if err != nil {
+		return nil, err
+	}
+	s.sample = sample
+	return s, nil
+}
+
+// Set replaces the setpoint.
+func (s *Streamer) Set(x, y, z float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fresh {
+		s.stats.Dropped++
+	}
+	s.sp = [3]float64{x, y, z}
+	s.have, s.fresh = true, true
+}
+
+// Stop makes Run send any unsent setpoint and return.
+func (s *Streamer) Stop() {
+	s.once.Do(func() { close(s.stop) })
+}
+
+// Run sends setpoints until Stop is called, ctx is done or send fails.
+// Nothing is sent before the first Set.
+func (s *Streamer) Run(ctx context.Context) error {
+	tick := time.NewTicker(s.period)
+	defer tick.Stop()
+
+	start := time.Now()
+	var last time.Duration
+	for {
+		select {
+		case <-tick.C:
+		case <-s.stop:
+			s.mu.Lock()
+			fresh := s.fresh
+			s.mu.Unlock()
+			if fresh {
+				return s.sendLatest()
+			}
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		// Ticks are due on multiples of the period since start.
+		n := (time.Since(start) + s.period/2) / s.period
+		jitter := time.Since(start.Add(n * s.period))
+		if jitter < 0 {
+			jitter = -jitter
+		}
+		s.mu.Lock()
+		if n > last+1 {
+			s.stats.Missed += uint64(n - last - 1)
+		}
+		s.ticks++
+		s.jsum += jitter
+		if jitter > s.stats.MaxJitter {
+			s.stats.MaxJitter = jitter
+		}
+		s.mu.Unlock()
+		last = n
+
+		end := false
+		if s.sample != nil {
+			var x, y, z float64
+			x, y, z, end = s.sample(time.Since(start))
+			s.Set(x, y, z)
+		}
+		if err := s.sendLatest(); err != nil || end {
+			return err
+		}
+	}
+}
+
+func (s *Streamer) sendLatest() error {
+	s.mu.Lock()
+	if !s.have {
+		s.mu.Unlock()
+		return nil
+	}
+	sp := s.sp
+	s.fresh = false
+	s.mu.Unlock()
+
+	t := time.Now()
+	err := s.send(sp[0], sp[1], sp[2])
+	latency := time.Since(t)
+
+	s.mu.Lock()
+	s.stats.Sent++
+	s.lsum += latency
+	if latency > s.stats.MaxLatency {
+		s.stats.MaxLatency = latency
+	}
+	s.mu.Unlock()
+	return err
+}
+
+// Stats returns the statistics so far.
+func (s *Streamer) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.stats
+	if s.ticks > 0 {
+		st.MeanJitter = s.jsum / time.Duration(s.ticks)
+	}
+	if st.Sent > 0 {
+		st.MeanLatency = s.lsum / time.Duration(st.Sent)
+	}
+	return st
+}
@@ -0,0 +1,194 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sends records the setpoints a Streamer sends.
+type sends struct {
+	mu sync.Mutex
+	sp [][3]float64
+}
+
+func (s *sends) send(x, y, z float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sp = append(s.sp, [3]float64{x, y, z})
+	return nil
+}
+
+func (s *sends) get() [][3]float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][3]float64(nil), s.sp...)
+}
+
+func TestNewPeriod(t *testing.T) {
+	for _, p := range []time.Duration{0, -time.Millisecond} {
+		if _, err := New(p, func(x, y, z float64) error { return nil }); err == nil {
+			t.Errorf("New(%v) succeeded", p)
+		}
+		if _, err := NewSampler(p, nil, func(x, y, z float64) error { return nil }); err == nil {
+			t.Errorf("NewSampler(%v) succeeded", p)
+		}
+	}
+}
+
+func TestNothingBeforeSet(t *testing.T) {
+	var sent sends
+	s, err := New(time.Millisecond, sent.send)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := s.Run(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Run = %v, want deadline exceeded", err)
+	}
+	if n := len(sent.get()); n != 0 || s.Stats().Sent != 0 {
+		t.Errorf("%d setpoints sent before Set", n)
+	}
+}
+
+func TestDropped(t *testing.T) {
+	var sent sends
+	s, err := New(time.Hour, sent.send)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Set(1, 0, 0)
+	s.Set(2, 0, 0)
+	s.Set(3, 0, 0)
+
+	// Stopping sends the latest setpoint, once
+	s.Stop()
+	s.Stop()
+	if err := s.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := sent.get(); len(got) != 1 || got[0] != [3]float64{3, 0, 0} {
+		t.Errorf("sent %v, want [[3 0 0]]", got)
+	}
+	if st := s.Stats(); st.Sent != 1 || st.Dropped != 2 || st.Missed != 0 {
+		t.Errorf("Stats = %v, want 1 sent and 2 dropped", st)
+	}
+}
+
+func TestResend(t *testing.T) {
+	var sent sends
+	s, err := New(time.Millisecond, sent.send)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Set(1, 2, 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error)
+	go func() { done <- s.Run(ctx) }()
+
+	// The setpoint is sent on every tick until it is replaced
+	for len(sent.get()) < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	s.Stop()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	got := sent.get()
+	for _, sp := range got {
+		if sp != [3]float64{1, 2, 3} {
+			t.Fatalf("sent %v, want only [1 2 3]", got)
+		}
+	}
+	if st := s.Stats(); st.Sent != uint64(len(got)) || st.Dropped != 0 {
+		t.Errorf("Stats = %v, want %d sent and none dropped", st, len(got))
+	}
+}
+
+func TestMissed(t *testing.T) {
+	const period = 10 * time.Millisecond
+	var s *Streamer
+	n := 0
+	s, err := New(period, func(x, y, z float64) error {
+		if n++; n == 1 {
+			time.Sleep(5 * period) // fall behind by four ticks
+		} else {
+			s.Stop()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Set(0, 0, 0)
+	if err := s.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// The ticker keeps one tick, so at least three are missed
+	if st := s.Stats(); st.Missed < 3 || st.Sent != 2 {
+		t.Errorf("Stats = %v, want 2 sent and 3 or more missed", st)
+	}
+}
+
+func TestSendError(t *testing.T) {
+	errSend := errors.New("send failed")
+	s, err := New(time.Millisecond, func(x, y, z float64) error { return errSend })
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Set(0, 0, 0)
+	if err := s.Run(context.Background()); err != errSend {
+		t.Errorf("Run = %v, want the send error", err)
+	}
+}
+
+func TestSampler(t *testing.T) {
+	const (
+		period = 5 * time.Millisecond
+		length = 100 * time.Millisecond
+	)
+	var sent sends
+	var times []time.Duration
+	s, err := NewSampler(period, func(d time.Duration) (x, y, z float64, last bool) {
+		times = append(times, d)
+		if d >= length {
+			return length.Seconds(), 0, 0, true
+		}
+		return d.Seconds(), 0, 0, false
+	}, sent.send)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if err := s.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < length || d > 2*length {
+		t.Errorf("Run took %v, want about %v", d, length)
+	}
+
+	// Every sample is sent in order, ending at the last
+	got := sent.get()
+	if len(got) != len(times) || len(got) < int(length/period)/2 {
+		t.Fatalf("sent %d setpoints for %d samples", len(got), len(times))
+	}
+	for i, sp := range got {
+		if sp[0] != times[i].Seconds() && i < len(got)-1 {
+			t.Errorf("setpoint %d = %v, want the sample at %v", i, sp, times[i])
+		}
+		if i > 0 && times[i] <= times[i-1] {
+			t.Errorf("sample %d at %v, after %v", i, times[i], times[i-1])
+		}
+	}
+	if last := got[len(got)-1]; last[0] != length.Seconds() {
+		t.Errorf("last setpoint %v, want the end", last)
+	}
+	if st := s.Stats(); st.Sent != uint64(len(got)) || st.Dropped != 0 {
+		t.Errorf("Stats = %v, want %d sent and none dropped", st, len(got))
+	}
+}
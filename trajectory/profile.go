@@ -0,0 +1,130 @@
+package trajectory
+
+import (
+	"errors"
+	"math"
+)
+
+// Limits bound motion along a path. Units are metres and seconds.
+type Limits struct {
+	Velocity     float64
+	Acceleration float64
+	Jerk         float64 // 0 for a trapezoidal profile
+}
+
+var errLimits = errors.New("trajectory: velocity and acceleration limits must be positive")
+
+// phase is a stretch of constant jerk starting at a given acceleration.
+type phase struct {
+	dur float64
+	j   float64
+	a0  float64
+}
+
+// profile moves a distance from rest to rest along a line.
+type profile struct {
+	dist   float64
+	phases []phase
+	dur    float64
+}
+
+// newProfile returns the fastest rest to rest profile covering dist within l.
+func newProfile(dist float64, l Limits) (*profile, error) {
+	if l.Velocity <= 0 || l.Acceleration <= 0 || l.Jerk < 0 {
+		return nil, errLimits
+	}
+	p := &profile{dist: dist}
+	if dist <= 0 {
+		return p, nil
+	}
+	if l.Jerk == 0 {
+		p.phases = trapezoid(dist, l)
+	} else {
+		p.phases = scurve(dist, l)
+	}
+	for _, ph := range p.phases {
+		p.dur += ph.dur
+	}
+	return p, nil
+}
+
+func trapezoid(d float64, l Limits) []phase {
+	v, a := l.Velocity, l.Acceleration
+	ta := v / a
+	tc := 0.0
+	if d >= v*ta {
+		tc = (d - v*ta) / v
+	} else {
+		ta = math.Sqrt(d / a) // never reaches v
+	}
+	return []phase{
+		{ta, 0, a},
+		{tc, 0, 0},
+		{ta, 0, -a},
+	}
+}
+
+// accel returns the jerk limited ramp from rest to v: the peak acceleration,
+// the time at constant jerk and the total ramp time.
+func accel(v float64, l Limits) (ap, tj, ta float64) {
+	a, j := l.Acceleration, l.Jerk
+	if v*j >= a*a {
+		return a, a / j, v/a + a/j
+	}
+	tj = math.Sqrt(v / j)
+	return j * tj, tj, 2 * tj
+}
+
+func scurve(d float64, l Limits) []phase {
+	// A symmetric ramp to v averages v/2, so a move peaking at v without
+	// cruising covers v * ta.
+	v := l.Velocity
+	ap, tj, ta := accel(v, l)
+	tc := 0.0
+	if d >= v*ta {
+		tc = (d - v*ta) / v
+	} else {
+		lo, hi := 0.0, v
+		for i := 0; i < 64; i++ {
+			v = (lo + hi) / 2
+			if _, _, t := accel(v, l); v*t > d {
+				hi = v
+			} else {
+				lo = v
+			}
+		}
+		ap, tj, ta = accel(lo, l)
+	}
+	j := l.Jerk
+	return []phase{
+		{tj, j, 0},
+		{ta - 2*tj, 0, ap},
+		{tj, -j, ap},
+		{tc, 0, 0},
+		{tj, -j, 0},
+		{ta - 2*tj, 0, -ap},
+		{tj, j, -ap},
+	}
+}
+
+// at returns the distance travelled t seconds into the profile.
+func (p *profile) at(t float64) float64 {
+	if t <= 0 {
+		return 0
+	}
+	if t >= p.dur {
+		return p.dist
+	}
+	var s, v float64
+	for _, ph := range p.phases {
+		tau := math.Min(t, ph.dur)
+		a := ph.a0
+		s += v*tau + a*tau*tau/2 + ph.j*tau*tau*tau/6
+		v += a*tau + ph.j*tau*tau/2
+		if t <= ph.dur {
+			break
+		}
+		t -= ph.dur
+	}
+	return math.Max(0, math.Min(p.dist, s))
+}
@@ -0,0 +1,106 @@
+package trajectory
+
+import (
+	"math"
+	"testing"
+)
+
+func TestProfileLimits(t *testing.T) {
+	scurve := Limits{Velocity: 0.1, Acceleration: 0.5, Jerk: 5}
+	for _, tt := range []struct {
+		name string
+		dist float64
+		l    Limits
+	}{
+		{"cruise", 0.3, scurve},
+		{"short of velocity", 0.01, scurve},
+		{"tiny", 1e-6, scurve},
+		// v*j < a*a, so the ramp never reaches the acceleration limit
+		{"short of acceleration", 0.3, Limits{Velocity: 0.02, Acceleration: 0.5, Jerk: 5}},
+		{"trapezoid", 0.3, Limits{Velocity: 0.1, Acceleration: 0.5}},
+		{"short trapezoid", 0.005, Limits{Velocity: 0.1, Acceleration: 0.5}},
+	} {
+		p, err := newProfile(tt.dist, tt.l)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if p.at(0) != 0 || p.at(-1) != 0 || p.at(p.dur) != tt.dist || p.at(p.dur+1) != tt.dist {
+			t.Errorf("%s: ends at %v and %v, want 0 and %v", tt.name, p.at(0), p.at(p.dur), tt.dist)
+		}
+
+		// Finite differences over the profile, with a little slack for the
+		// steps that straddle a change of phase
+		const n = 20000
+		dt := p.dur / n
+		const slack = 1.01
+		var v0, a0, maxV, maxA, maxJ float64
+		s0 := 0.0
+		for i := 1; i <= n; i++ {
+			s := p.at(float64(i) * dt)
+			if s < s0 {
+				t.Errorf("%s: went back from %v to %v at %v s", tt.name, s0, s, float64(i)*dt)
+				break
+			}
+			v := (s - s0) / dt
+			a := (v - v0) / dt
+			maxV = math.Max(maxV, v)
+			if i > 1 {
+				maxA = math.Max(maxA, math.Abs(a))
+			}
+			if i > 2 {
+				maxJ = math.Max(maxJ, math.Abs(a-a0)/dt)
+			}
+			s0, v0, a0 = s, v, a
+		}
+		if maxV > tt.l.Velocity*slack {
+			t.Errorf("%s: velocity %v, limit %v", tt.name, maxV, tt.l.Velocity)
+		}
+		if maxA > tt.l.Acceleration*slack {
+			t.Errorf("%s: acceleration %v, limit %v", tt.name, maxA, tt.l.Acceleration)
+		}
+		if tt.l.Jerk > 0 && maxJ > tt.l.Jerk*slack {
+			t.Errorf("%s: jerk %v, limit %v", tt.name, maxJ, tt.l.Jerk)
+		}
+		if tt.name == "cruise" && maxV < tt.l.Velocity/slack {
+			t.Errorf("%s: peak velocity %v, want %v", tt.name, maxV, tt.l.Velocity)
+		}
+
+		// From rest to rest
+		for _, at := range []float64{dt, p.dur} {
+			if v := (p.at(at) - p.at(at-dt)) / dt; v > tt.l.Velocity/100 {
+				t.Errorf("%s: moving at %v m/s at %v s, want at rest", tt.name, v, at)
+			}
+		}
+	}
+}
+
+func TestProfileFastest(t *testing.T) {
+	// Moves long enough to cruise take the ramps plus the cruise
+	l := Limits{Velocity: 0.1, Acceleration: 0.5, Jerk: 5}
+	p, err := newProfile(1, l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ramp := l.Velocity/l.Acceleration + l.Acceleration/l.Jerk
+	if want := 1/l.Velocity + ramp; math.Abs(p.dur-want) > 1e-9 {
+		t.Errorf("duration %v s, want %v s", p.dur, want)
+	}
+}
+
+func TestProfileErrors(t *testing.T) {
+	for _, l := range []Limits{
+		{},
+		{Velocity: 0.1},
+		{Acceleration: 0.1},
+		{Velocity: -1, Acceleration: 1},
+		{Velocity: 1, Acceleration: 1, Jerk: -1},
+	} {
+		if _, err := newProfile(1, l); err == nil {
+			t.Errorf("newProfile with %+v succeeded", l)
+		}
+	}
+	p, err := newProfile(0, Limits{Velocity: 1, Acceleration: 1})
+	if err != nil || p.dur != 0 || p.at(1) != 0 {
+		t.Errorf("newProfile(0) = %+v, %v, want an empty profile", p, err)
+	}
+}
@@ -0,0 +1,197 @@
+// Package trajectory generates time parameterised setpoints for the arm.
+package trajectory
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+)
+
+// Point is a position in the POINT frame, in metres.
+type Point struct {
+	X, Y, Z float64
+}
+
+func (p Point) sub(q Point) Point     { return Point{p.X - q.X, p.Y - q.Y, p.Z - q.Z} }
+func (p Point) add(q Point) Point     { return Point{p.X + q.X, p.Y + q.Y, p.Z + q.Z} }
+func (p Point) scale(k float64) Point { return Point{p.X * k, p.Y * k, p.Z * k} }
+func (p Point) dist(q Point) float64  { d := p.sub(q); return math.Sqrt(d.X*d.X + d.Y*d.Y + d.Z*d.Z) }
+
+// piece follows a polyline from rest to rest.
+type piece struct {
+	path  []Point
+	cum   []float64 // arc length at each path point
+	prof  *profile
+	start float64 // seconds into the trajectory
+}
+
+func (pc *piece) at(t float64) Point {
+	s := pc.prof.at(t - pc.start)
+	i := sort.SearchFloat64s(pc.cum, s)
+	switch {
+	case i == 0:
+		return pc.path[0]
+	case i >= len(pc.path):
+		return pc.path[len(pc.path)-1]
+	}
+	a, b := pc.path[i-1], pc.path[i]
+	k := (s - pc.cum[i-1]) / (pc.cum[i] - pc.cum[i-1])
+	return a.add(b.sub(a).scale(k))
+}
+
+// Trajectory is a sequence of moves, each starting and ending at rest.
+type Trajectory struct {
+	pieces []*piece
+	dur    float64
+}
+
+// New returns a trajectory following path within l, starting and ending at
+// rest. Speed is not reduced at corners, so path should be a finely sampled
+// smooth curve; use Waypoints to stop at each point instead.
+func New(path []Point, l Limits) (*Trajectory, error) {
+	if len(path) == 0 {
+		return nil, errors.New("trajectory: empty path")
+	}
+	pc := &piece{
+		path: path,
+		cum:  make([]float64, len(path)),
+	}
+	for i := 1; i < len(path); i++ {
+		pc.cum[i] = pc.cum[i-1] + path[i].dist(path[i-1])
+	}
+	var err error
+	if pc.prof, err = newProfile(pc.cum[len(pc.cum)-1], l); err != nil {
+		return nil, err
+	}
+	return &Trajectory{
+		pieces: []*piece{pc},
+		dur:    pc.prof.dur,
+	}, nil
+}
+
+// Hold returns a trajectory staying at p for d.
+func Hold(p Point, d time.Duration) *Trajectory {
+	pc := &piece{
+		path: []Point{p},
+		cum:  []float64{0},
+		prof: &profile{dur: d.Seconds()},
+	}
+	return &Trajectory{
+		pieces: []*piece{pc},
+		dur:    pc.prof.dur,
+	}
+}
+
+// Waypoints returns a trajectory moving in straight lines between points,
+// stopping at each.
+func Waypoints(points []Point, l Limits) (*Trajectory, error) {
+	if len(points) < 2 {
+		return New(points, l)
+	}
+	var ts []*Trajectory
+	for i := 1; i < len(points); i++ {
+		t, err := New(points[i-1:i+1], l)
+		if err != nil {
+			return nil, err
+		}
+		ts = append(ts, t)
+	}
+	return Join(ts...), nil
+}
+
+// MaxCorner is a default for Corners: the largest change of direction, in
+// radians, blended through without stopping.
+var MaxCorner = 10 * math.Pi / 180
+
+// Corners returns a trajectory following path like New, but stopping at
+// every corner sharper than max radians.
+func Corners(path []Point, l Limits, max float64) (*Trajectory, error) {
+	var ts []*Trajectory
+	start := 0
+	for i := 1; i < len(path)-1; i++ {
+		if corner(path[i-1], path[i], path[i+1]) <= max {
+			continue
+		}
+		t, err := New(path[start:i+1], l)
+		if err != nil {
+			return nil, err
+		}
+		ts = append(ts, t)
+		start = i
+	}
+	t, err := New(path[start:], l)
+	if err != nil {
+		return nil, err
+	}
+	return Join(append(ts, t)...), nil
+}
+
+// corner returns the change of direction at q between p and r.
+func corner(p, q, r Point) float64 {
+	u, v := q.sub(p), r.sub(q)
+	nu, nv := q.dist(p), r.dist(q)
+	if nu == 0 || nv == 0 {
+		return 0
+	}
+	c := (u.X*v.X + u.Y*v.Y + u.Z*v.Z) / (nu * nv)
+	return math.Acos(math.Max(-1, math.Min(1, c)))
+}
+
+// Join returns the trajectories run one after another.
+func Join(ts ...*Trajectory) *Trajectory {
+	j := &Trajectory{}
+	for _, t := range ts {
+		for _, pc := range t.pieces {
+			cp := *pc
+			cp.start += j.dur
+			j.pieces = append(j.pieces, &cp)
+		}
+		j.dur += t.dur
+	}
+	return j
+}
+
+// Duration returns the time to run the trajectory.
+func (t *Trajectory) Duration() time.Duration {
+	return time.Duration(t.dur * float64(time.Second))
+}
+
+// At returns the setpoint d into the trajectory. An empty trajectory, from
+// Join with no arguments, is at the origin.
+func (t *Trajectory) At(d time.Duration) Point {
+	if len(t.pieces) == 0 {
+		return Point{}
+	}
+	s := d.Seconds()
+	i := sort.Search(len(t.pieces), func(i int) bool {
+		pc := t.pieces[i]
+		return pc.start+pc.prof.dur >= s
+	})
+	if i == len(t.pieces) {
+		i--
+	}
+	return t.pieces[i].at(s)
+}
+
+// End returns the final setpoint.
+func (t *Trajectory) End() Point {
+	if len(t.pieces) == 0 {
+		return Point{}
+	}
+	pc := t.pieces[len(t.pieces)-1]
+	return pc.path[len(pc.path)-1]
+}
+
+// Sample returns setpoints every period, including the end point. It returns
+// nil if period is not positive.
+func (t *Trajectory) Sample(period time.Duration) []Point {
+	if period <= 0 {
+		return nil
+	}
+	var ps []Point
+	for d := time.Duration(0); d < t.Duration(); d += period {
+		ps = append(ps, t.At(d))
+	}
+	return append(ps, t.End())
+}
@@ -0,0 +1,181 @@
+package trajectory
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+var limits = Limits{Velocity: 0.1, Acceleration: 0.5, Jerk: 5}
+
+// speed returns the speed along t at d.
+func speed(t *Trajectory, d time.Duration) float64 {
+	const dt = 100 * time.Microsecond
+	return t.At(d+dt).dist(t.At(d-dt)) / (2 * dt).Seconds()
+}
+
+func TestNew(t *testing.T) {
+	path := []Point{{0, 0, 0}, {0.03, 0, 0}, {0.03, 0.04, 0}}
+	tr, err := New(path, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.At(0) != path[0] || tr.At(-time.Second) != path[0] {
+		t.Errorf("At(0) = %v, want %v", tr.At(0), path[0])
+	}
+	if tr.End() != path[2] || tr.At(tr.Duration()) != path[2] || tr.At(tr.Duration()+time.Second) != path[2] {
+		t.Errorf("End = %v, At(end) = %v, want %v", tr.End(), tr.At(tr.Duration()), path[2])
+	}
+
+	// Progress along the path never goes back
+	prev := 0.0
+	for d := time.Duration(0); d <= tr.Duration(); d += time.Millisecond {
+		p := tr.At(d)
+		s := p.X + p.Y // arc length along this path
+		if s < prev-1e-12 {
+			t.Fatalf("At(%v) = %v, went back", d, p)
+		}
+		prev = s
+		if v := speed(tr, d); v > limits.Velocity*1.01 {
+			t.Errorf("speed %v at %v, limit %v", v, d, limits.Velocity)
+		}
+	}
+
+	if _, err := New(nil, limits); err == nil {
+		t.Error("New with an empty path succeeded")
+	}
+	if _, err := New(path, Limits{}); err == nil {
+		t.Error("New with no limits succeeded")
+	}
+}
+
+func TestCorners(t *testing.T) {
+	// A square corner is stopped at
+	square := []Point{{0, 0, 0}, {0.02, 0, 0}, {0.04, 0, 0}, {0.04, 0.02, 0}, {0.04, 0.04, 0}}
+	tr, err := Corners(square, limits, MaxCorner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := New(square[:3], limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corner := first.Duration()
+	if p := tr.At(corner); p.dist(square[2]) > 1e-12 {
+		t.Errorf("At(%v) = %v, want the corner %v", corner, p, square[2])
+	}
+	if v := speed(tr, corner); v > 1e-3 {
+		t.Errorf("speed %v at the corner, want at rest", v)
+	}
+	if tr.End() != square[4] {
+		t.Errorf("End = %v, want %v", tr.End(), square[4])
+	}
+
+	// A finely sampled circle is followed without stopping
+	var circle []Point
+	for i := 0; i <= 360; i++ {
+		a := float64(i) * math.Pi / 180
+		circle = append(circle, Point{X: 0.03 * math.Cos(a), Y: 0.03 * math.Sin(a)})
+	}
+	smooth, err := Corners(circle, limits, MaxCorner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	whole, err := New(circle, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if smooth.Duration() != whole.Duration() {
+		t.Errorf("circle takes %v, want %v without stops", smooth.Duration(), whole.Duration())
+	}
+	if v := speed(smooth, smooth.Duration()/2); v < limits.Velocity*0.99 {
+		t.Errorf("speed %v half way round, want %v", v, limits.Velocity)
+	}
+}
+
+func TestWaypoints(t *testing.T) {
+	points := []Point{{0, 0, 0}, {0.01, 0, 0}, {0.01, 0.01, 0.01}}
+	tr, err := Waypoints(points, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var at time.Duration
+	for i := 1; i < len(points); i++ {
+		leg, err := New(points[i-1:i+1], limits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		at += leg.Duration()
+		if p := tr.At(at); p.dist(points[i]) > 1e-12 {
+			t.Errorf("At(%v) = %v, want waypoint %v", at, p, points[i])
+		}
+	}
+}
+
+func TestJoin(t *testing.T) {
+	a, err := New([]Point{{0, 0, 0}, {0.01, 0, 0}}, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hold := Hold(Point{0.01, 0, 0}, 50*time.Millisecond)
+	b, err := New([]Point{{0.01, 0, 0}, {0.01, 0, -0.02}}, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := Join(a, hold, b)
+	if want := a.Duration() + hold.Duration() + b.Duration(); (j.Duration() - want).Abs() > time.Microsecond {
+		t.Errorf("Duration = %v, want %v", j.Duration(), want)
+	}
+	for _, d := range []time.Duration{0, 10 * time.Millisecond, a.Duration()} {
+		if j.At(d) != a.At(d) {
+			t.Errorf("At(%v) = %v, want %v", d, j.At(d), a.At(d))
+		}
+	}
+	if p := j.At(a.Duration() + 25*time.Millisecond); p != (Point{0.01, 0, 0}) {
+		t.Errorf("At during the hold = %v", p)
+	}
+	off := a.Duration() + hold.Duration()
+	for _, d := range []time.Duration{time.Millisecond, b.Duration() / 2} {
+		if p, q := j.At(off+d), b.At(d); p.dist(q) > 1e-9 {
+			t.Errorf("At(%v) = %v, want %v", off+d, p, q)
+		}
+	}
+	if j.End() != b.End() {
+		t.Errorf("End = %v, want %v", j.End(), b.End())
+	}
+
+	// Joining nothing gives an empty trajectory at the origin
+	empty := Join()
+	if empty.Duration() != 0 || empty.At(time.Second) != (Point{}) || empty.End() != (Point{}) {
+		t.Errorf("Join() = %v, %v, %v", empty.Duration(), empty.At(time.Second), empty.End())
+	}
+}
+
+func TestSample(t *testing.T) {
+	tr, err := New([]Point{{0, 0, 0}, {0.02, 0, 0}}, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const period = 3 * time.Millisecond
+	ps := tr.Sample(period)
+	if want := int((tr.Duration()+period-1)/period) + 1; len(ps) != want {
+		t.Errorf("%d samples over %v, want %d", len(ps), tr.Duration(), want)
+	}
+	if ps[0] != (Point{}) || ps[len(ps)-1] != tr.End() {
+		t.Errorf("samples from %v to %v, want %v to %v", ps[0], ps[len(ps)-1], Point{}, tr.End())
+	}
+	for i := 1; i < len(ps); i++ {
+		if ps[i].X < ps[i-1].X {
+			t.Errorf("sample %d at %v, behind %v", i, ps[i], ps[i-1])
+		}
+	}
+
+	for _, p := range []time.Duration{0, -time.Millisecond} {
+		if ps := tr.Sample(p); ps != nil {
+			t.Errorf("Sample(%v) = %d points, want nil", p, len(ps))
+		}
+	}
+	if ps := Join().Sample(period); len(ps) != 1 || ps[0] != (Point{}) {
+		t.Errorf("Join().Sample = %v, want the origin", ps)
+	}
+}
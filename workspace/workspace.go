@@ -0,0 +1,213 @@
+// Package workspace limits POINT commands to the volume the arm can reach.
+package workspace
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sync/atomic"
+
+	"github.com/afking/godelta/kinematics"
+)
+
+// Bounds is a region of the POINT frame.
+type Bounds interface {
+	// Contains reports whether the point is inside the region.
+	Contains(x, y, z float64) bool
+	// Nearest returns the closest point inside the region.
+	Nearest(x, y, z float64) (float64, float64, float64)
+}
+
+// Cylinder is a vertical cylinder about the z axis.
+type Cylinder struct {
+	Radius     float64
+	ZMin, ZMax float64
+}
+
+func (c Cylinder) Contains(x, y, z float64) bool {
+	return math.Hypot(x, y) <= c.Radius && z >= c.ZMin && z <= c.ZMax
+}
+
+func (c Cylinder) Nearest(x, y, z float64) (float64, float64, float64) {
+	if r := math.Hypot(x, y); r > c.Radius {
+		k := c.Radius / r
+		for math.Hypot(x*k, y*k) > c.Radius { // rounded outwards
+			k = math.Nextafter(k, 0)
+		}
+		x, y = x*k, y*k
+	}
+	return x, y, math.Max(c.ZMin, math.Min(c.ZMax, z))
+}
+
+// Sphere is a ball about a centre point.
+type Sphere struct {
+	X, Y, Z float64
+	Radius  float64
+}
+
+func (s Sphere) Contains(x, y, z float64) bool {
+	return dist(x-s.X, y-s.Y, z-s.Z) <= s.Radius
+}
+
+func (s Sphere) Nearest(x, y, z float64) (float64, float64, float64) {
+	dx, dy, dz := x-s.X, y-s.Y, z-s.Z
+	d := dist(dx, dy, dz)
+	if d <= s.Radius {
+		return x, y, z
+	}
+	k := s.Radius / d
+	for !s.Contains(s.X+dx*k, s.Y+dy*k, s.Z+dz*k) { // rounded outwards
+		k = math.Nextafter(k, 0)
+	}
+	return s.X + dx*k, s.Y + dy*k, s.Z + dz*k
+}
+
+// Reach is every point the kinematics can solve within the motor angle
+// limits. Nearest searches along the line to the origin, which must be
+// reachable.
+type Reach struct {
+	Geometry kinematics.Geometry
+
+	// Motor angle limits in radians, ignored if equal.
+	MinAngle, MaxAngle float64
+}
+
+func (r Reach) Contains(x, y, z float64) bool {
+	a, err := r.Geometry.Inverse(x, y, z)
+	if err != nil {
+		return false
+	}
+	if r.MinAngle == r.MaxAngle {
+		return true
+	}
+	for _, t := range a {
+		if t < r.MinAngle || t > r.MaxAngle {
+			return false
+		}
+	}
+	return true
+}
+
+func (r Reach) Nearest(x, y, z float64) (float64, float64, float64) {
+	if r.Contains(x, y, z) {
+		return x, y, z
+	}
+	lo, hi := 0.0, 1.0
+	for i := 0; i < 32; i++ {
+		k := (lo + hi) / 2
+		if r.Contains(x*k, y*k, z*k) {
+			lo = k
+		} else {
+			hi = k
+		}
+	}
+	return x * lo, y * lo, z * lo
+}
+
+func dist(x, y, z float64) float64 {
+	return math.Sqrt(x*x + y*y + z*z)
+}
+
+// Mode selects what happens to points outside the bounds.
+type Mode int
+
+const (
+	Reject Mode = iota // return an error and send nothing
+	Clamp              // move to the nearest point inside
+	Warn               // log and send unchanged
+)
+
+var modeNames = []string{"reject", "clamp", "warn"}
+
+func (m Mode) String() string {
+	if m < 0 || int(m) >= len(modeNames) {
+		return fmt.Sprintf("Mode(%d)", int(m))
+	}
+	return modeNames[m]
+}
+
+// ParseMode parses "reject", "clamp" or "warn".
+func ParseMode(s string) (Mode, error) {
+	for i, n := range modeNames {
+		if s == n {
+			return Mode(i), nil
+		}
+	}
+	return 0, fmt.Errorf("workspace: unknown mode %q", s)
+}
+
+// OutsideError reports a rejected point.
+type OutsideError struct {
+	X, Y, Z float64
+}
+
+func (e *OutsideError) Error() string {
+	return fmt.Sprintf("workspace: point (%f, %f, %f) outside workspace", e.X, e.Y, e.Z)
+}
+
+// Workspace checks points against Bounds. It is safe for concurrent use.
+type Workspace struct {
+	Bounds Bounds
+	Mode   Mode
+
+	rejected uint64
+	clamped  uint64
+	warned   uint64
+}
+
+// Check returns the point to send in place of (x, y, z). In Reject mode
+// points outside the bounds return an *OutsideError, as do points in Clamp
+// mode whose nearest point is not inside either. Points that are NaN or
+// infinite are always rejected.
+func (w *Workspace) Check(x, y, z float64) (float64, float64, float64, error) {
+	if !finite(x, y, z) {
+		return w.reject(x, y, z)
+	}
+	if w.Bounds == nil || w.Bounds.Contains(x, y, z) {
+		return x, y, z, nil
+	}
+
+	switch w.Mode {
+	case Clamp:
+		cx, cy, cz := w.Bounds.Nearest(x, y, z)
+		if !finite(cx, cy, cz) || !w.Bounds.Contains(cx, cy, cz) {
+			return w.reject(x, y, z) // nothing inside to clamp to
+		}
+		atomic.AddUint64(&w.clamped, 1)
+		return cx, cy, cz, nil
+	case Warn:
+		atomic.AddUint64(&w.warned, 1)
+		log.Printf("workspace: POINT(%f, %f, %f) outside workspace", x, y, z)
+		return x, y, z, nil
+	}
+	return w.reject(x, y, z)
+}
+
+func (w *Workspace) reject(x, y, z float64) (float64, float64, float64, error) {
+	atomic.AddUint64(&w.rejected, 1)
+	return x, y, z, &OutsideError{x, y, z}
+}
+
+func finite(vs ...float64) bool {
+	for _, v := range vs {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// Rejected returns the number of points rejected.
+func (w *Workspace) Rejected() uint64 {
+	return atomic.LoadUint64(&w.rejected)
+}
+
+// Clamped returns the number of points moved inside the bounds.
+func (w *Workspace) Clamped() uint64 {
+	return atomic.LoadUint64(&w.clamped)
+}
+
+// Warned returns the number of points sent outside the bounds in Warn mode.
+func (w *Workspace) Warned() uint64 {
+	return atomic.LoadUint64(&w.warned)
+}
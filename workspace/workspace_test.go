@@ -0,0 +1,138 @@
+package workspace
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/afking/godelta/kinematics"
+)
+
+var (
+	cylinder = Cylinder{Radius: 0.05, ZMin: -0.05, ZMax: 0.05}
+	sphere   = Sphere{Z: 0.01, Radius: 0.05}
+	reach    = Reach{Geometry: kinematics.Default}
+)
+
+func TestCheck(t *testing.T) {
+	type point [3]float64
+	for _, tt := range []struct {
+		name   string
+		bounds Bounds
+		mode   Mode
+		in     point
+		want   point
+		reject bool
+	}{
+		{"inside cylinder", cylinder, Reject, point{0.01, 0.02, 0.03}, point{0.01, 0.02, 0.03}, false},
+		{"inside sphere", sphere, Reject, point{0, 0, 0.05}, point{0, 0, 0.05}, false},
+		{"inside reach", reach, Reject, point{0.02, -0.02, -0.02}, point{0.02, -0.02, -0.02}, false},
+		{"no bounds", nil, Reject, point{1, 2, 3}, point{1, 2, 3}, false},
+
+		{"outside cylinder", cylinder, Reject, point{0.06, 0, 0}, point{0.06, 0, 0}, true},
+		{"below cylinder", cylinder, Reject, point{0, 0, -0.06}, point{0, 0, -0.06}, true},
+		{"outside sphere", sphere, Reject, point{0, 0, 0.07}, point{0, 0, 0.07}, true},
+		{"outside reach", reach, Reject, point{0.5, 0, 0}, point{0.5, 0, 0}, true},
+
+		{"clamp cylinder side", cylinder, Clamp, point{0.06, 0.08, 0}, point{0.03, 0.04, 0}, false},
+		{"clamp cylinder top", cylinder, Clamp, point{0, 0, 0.2}, point{0, 0, 0.05}, false},
+		{"clamp sphere", sphere, Clamp, point{0, 0.1, 0.01}, point{0, 0.05, 0.01}, false},
+		{"clamp far", cylinder, Clamp, point{math.MaxFloat64, 0, 0}, point{0.05, 0, 0}, false},
+		{"clamp sphere off centre", Sphere{X: 0.1, Y: 0.3, Z: 0.7, Radius: 0.1}, Clamp, point{0.1, 0.3, 0.9}, point{0.1, 0.3, 0.8}, false},
+		// The home angles are outside the limits, so no point on the line to
+		// the origin is reachable
+		{"clamp unreachable", Reach{Geometry: kinematics.Default, MinAngle: 0.5, MaxAngle: 1}, Clamp, point{0.2, 0, 0}, point{0.2, 0, 0}, true},
+
+		{"warn", cylinder, Warn, point{0.06, 0, 0}, point{0.06, 0, 0}, false},
+	} {
+		w := &Workspace{Bounds: tt.bounds, Mode: tt.mode}
+		x, y, z, err := w.Check(tt.in[0], tt.in[1], tt.in[2])
+		var oerr *OutsideError
+		if tt.reject != errors.As(err, &oerr) {
+			t.Errorf("%s: Check(%v) error %v, want rejected %v", tt.name, tt.in, err, tt.reject)
+			continue
+		}
+		if !tt.reject && tt.bounds != nil && tt.mode == Clamp && !tt.bounds.Contains(x, y, z) {
+			t.Errorf("%s: Check(%v) = (%v, %v, %v), outside the bounds", tt.name, tt.in, x, y, z)
+		}
+		got := point{x, y, z}
+		for i := range got {
+			if math.Abs(got[i]-tt.want[i]) > 1e-12 {
+				t.Errorf("%s: Check(%v) = %v, want %v", tt.name, tt.in, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestCheckCounts(t *testing.T) {
+	for _, tt := range []struct {
+		mode                      Mode
+		rejected, clamped, warned uint64
+	}{
+		{Reject, 1, 0, 0},
+		{Clamp, 0, 1, 0},
+		{Warn, 0, 0, 1},
+	} {
+		w := &Workspace{Bounds: cylinder, Mode: tt.mode}
+		w.Check(0, 0, 0)
+		w.Check(1, 0, 0)
+		if w.Rejected() != tt.rejected || w.Clamped() != tt.clamped || w.Warned() != tt.warned {
+			t.Errorf("%v: rejected %d, clamped %d, warned %d, want %d, %d, %d", tt.mode,
+				w.Rejected(), w.Clamped(), w.Warned(), tt.rejected, tt.clamped, tt.warned)
+		}
+	}
+}
+
+func TestCheckNonFinite(t *testing.T) {
+	inf, nan := math.Inf(1), math.NaN()
+	points := [][3]float64{
+		{nan, 0, 0},
+		{0, nan, 0},
+		{0, 0, nan},
+		{inf, 0, 0},
+		{0, math.Inf(-1), 0},
+		{0, 0, inf},
+		{inf, inf, 0},
+	}
+	for _, b := range []Bounds{nil, cylinder, sphere, reach} {
+		for _, mode := range []Mode{Reject, Clamp, Warn} {
+			w := &Workspace{Bounds: b, Mode: mode}
+			for _, p := range points {
+				x, y, z, err := w.Check(p[0], p[1], p[2])
+				var oerr *OutsideError
+				if !errors.As(err, &oerr) {
+					t.Errorf("%T %v: Check(%v) = (%v, %v, %v), %v, want *OutsideError", b, mode, p, x, y, z, err)
+				}
+			}
+			if n := w.Rejected(); n != uint64(len(points)) {
+				t.Errorf("%T %v: Rejected = %d, want %d", b, mode, n, len(points))
+			}
+		}
+	}
+}
+
+func TestReachNearest(t *testing.T) {
+	x, y, z := reach.Nearest(0.5, 0.5, 0)
+	if !reach.Contains(x, y, z) {
+		t.Errorf("Nearest(0.5, 0.5, 0) = (%v, %v, %v), not reachable", x, y, z)
+	}
+	if x <= 0 || x != y || z != 0 {
+		t.Errorf("Nearest(0.5, 0.5, 0) = (%v, %v, %v), want along the line to the origin", x, y, z)
+	}
+	if a, _ := kinematics.Default.Inverse(x*1.01, y*1.01, z); reach.Contains(x*1.01, y*1.01, z) {
+		t.Errorf("Nearest(0.5, 0.5, 0) = (%v, %v, %v) stops short, 1%% further reaches %v", x, y, z, a)
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	for _, m := range []Mode{Reject, Clamp, Warn} {
+		got, err := ParseMode(m.String())
+		if err != nil || got != m {
+			t.Errorf("ParseMode(%q) = %v, %v", m.String(), got, err)
+		}
+	}
+	if _, err := ParseMode("ignore"); err == nil {
+		t.Error("ParseMode(\"ignore\") succeeded")
+	}
+}
@@ -1,293 +1,479 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
-	//"math"
+	"math"
+	"sync"
 	"time"
 
-	"github.com/kylelemons/gousb/usb"
-	//"github.com/kylelemons/gousb/usbid"
+	"github.com/afking/godelta/client"
+	"github.com/afking/godelta/config"
+	"github.com/afking/godelta/gamepad"
+	"github.com/afking/godelta/stream"
+	"github.com/afking/godelta/trajectory"
+	"github.com/codegangsta/cli"
 )
 
-const (
-	Empty      byte = iota // 00000000 ( 0) no LEDs
-	WarnAll                // 00000001 ( 1) flash all briefly
-	NewPlayer1             // 00000010 ( 2) p1 flash then solid
-	NewPlayer2             // 00000011
-	NewPlayer3             // 00000100
-	NewPlayer4             // 00000101
-	Player1                // 00000110 ( 6) p1 solid
-	Player2                // 00000111
-	Player3                // 00001000
-	Player4                // 00001001
-	Waiting                // 00001010 (10) empty w/ loops
-	WarnPlayer             // 00001011 (11) flash active
-	_                      // 00001100 (12) empty
-	Battery                // 00001101 (13) squiggle
-	Searching              // 00001110 (14) slow flash
-	Booting                // 00001111 (15) solid then flash
-)
+// speeds are the steps the speed buttons move between, shown on the LED
+// ring as players 1 to 4
+var speeds = []float64{0.25, 0.5, 0.75, 1}
 
 type xboxCtrl struct {
-	ctx        *usb.Context
-	controller *usb.Device
-	in         usb.Endpoint
-	out        usb.Endpoint
-
-	// Commands
-	last [512]byte
-	cur  [512]byte
-
-	// JoyStick - 16bit
-	xLS float64
-	yLS float64
-	xRS float64
-	yRS float64
+	pad     gamepad.InputSource
+	stream  *stream.Streamer
+	mapping *gamepad.Mapping
+	actions []padAction
+	fb      *feedback
+	limits  trajectory.Limits // of home and waypoint moves
+
+	mu        sync.Mutex
+	move      *trajectory.Trajectory // of the origin, nil when there is none
+	moveStart time.Time
+
+	sendErrs  int       // setpoints failed since sendErrAt
+	sendErrAt time.Time // when a failed setpoint was last logged
+
+	last      gamepad.State
+	speed     int // index into speeds
+	waypoints [][3]float64
+	recall    int // next waypoint to recall
 }
 
-func xboxDriver() error {
-	x := &xboxCtrl{}
+// padAction is an action bound to a button
+type padAction struct {
+	button gamepad.Button
+	do     func()
+	now    bool // run at once rather than queued behind other actions
+}
 
-	// One context should be opened for the application.
-	x.ctx = usb.NewContext()
-	defer x.ctx.Close()
+// padUpdate is the latest pad state with the buttons pressed since the last
+// update was taken
+type padUpdate struct {
+	state   gamepad.State
+	pressed gamepad.Button
+}
 
-	// ListDevices is used to find the devices to open.
-	devs, err := x.ctx.ListDevices(func(desc *usb.Descriptor) bool {
-		if desc.Vendor == usb.ID(0x045e) && desc.Product == usb.ID(0x028e) {
-			return true
-		} else {
-			return false
-		}
-	})
+func xboxDriver(c config.Controller, l trajectory.Limits) error {
+	x, err := newXbox(c, l)
 	if err != nil {
 		return err
-	} else if len(devs) == 0 {
-		return fmt.Errorf("Xbox controller was not detected.")
 	}
-	defer func() {
-		for _, d := range devs {
-			d.Close()
-		}
-	}()
 
-	if len(devs) != 1 {
-		return fmt.Errorf("Found %d devices, want 1", len(devs))
+	timeout := 60 * time.Second
+	if cfg.Heartbeat > 0 {
+		// Time out idle reads so the loop keeps beating the heart
+		timeout = cfg.Watchdog / 2
 	}
-	// Setup Device, Grab first controller
-	x.controller = devs[0]
-
-	if err := x.controller.Reset(); err != nil {
+	pad, err := openPad(c, timeout)
+	if err != nil {
 		return err
 	}
 
-	// Open Endpoints
-	// config = 1, iface = 0, setup = 0, endIn = 1, endOut = 1
+	return x.run(cmdCtx, pad)
+}
 
-	x.in, err = x.controller.OpenEndpoint(01, 00, 00, 01|uint8(usb.ENDPOINT_DIR_IN))
+// newXbox returns a controller for the configuration, with home and waypoint
+// moves within l
+func newXbox(c config.Controller, l trajectory.Limits) (*xboxCtrl, error) {
+	m, err := padMapping(c)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	x.out, err = x.controller.OpenEndpoint(01, 00, 00, 01|uint8(usb.ENDPOINT_DIR_OUT))
+	if _, err := trajectory.New([]trajectory.Point{{}}, l); err != nil {
+		return nil, err // checks the limits
+	}
+	period := time.Second / time.Duration(c.Rate)
+	x := &xboxCtrl{mapping: m, limits: l}
+	if err := x.bind(cfg.Buttons); err != nil {
+		return nil, err
+	}
+	x.stream, err = stream.New(period, func(px, py, pz float64) error {
+		if halt.Triggered() {
+			return nil
+		}
+		// Stepped on the stream's ticks so jogging and moves run at a
+		// steady rate
+		x.followMove()
+		p := x.mapping.Step([3]float64{px, py, pz}, period)
+
+		// Setpoints are dropped while reconnecting, which is logged once
+		// by the connection.
+		clamped := ws.Clamped()
+		err := msgPoint(p[0], p[1], p[2])
+		if ws.Clamped() > clamped {
+			x.fb.clamped(time.Now())
+		}
+		if err != nil && !errors.Is(err, client.ErrDisconnected) {
+			x.logSendError(err)
+		}
+		return nil
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	x.xbox360()
-	return nil
+	return x, nil
 }
 
-func (x *xboxCtrl) send() {
-	// Format for delta arm
-	// 0.04 m radius, 16 bit max = 32768
-	dFmt := func(a float64) float64 {
-		return a / 32768 * 0.04
-	}
+// sendErrorLog is the least time between logging failed setpoints, which
+// otherwise come at the stream rate while the stick is held outside the
+// workspace
+const sendErrorLog = time.Second
 
-	if err := msgPoint(dFmt(x.xLS), dFmt(x.yLS), dFmt(x.yRS)); err != nil {
+// logSendError logs a failed setpoint, at most once every sendErrorLog. It is
+// called only from the stream.
+func (x *xboxCtrl) logSendError(err error) {
+	x.sendErrs++
+	if time.Since(x.sendErrAt) < sendErrorLog {
+		return
+	}
+	if x.sendErrs > 1 {
+		log.Printf("xbox: %v (%d setpoints failed)", err, x.sendErrs)
+	} else {
 		log.Println("xbox: ", err)
 	}
+	x.sendErrs, x.sendErrAt = 0, time.Now()
 }
 
-func (x *xboxCtrl) xbox360() {
-	// https://github.com/Grumbel/xboxdrv/blob/master/PROTOCOL
-	x.led(Empty)
-	time.Sleep(1 * time.Second)
-	x.setPlayer(Player1)
-
-	/*
-		var b [512]byte
-		for {
-			n, err := x.in.Read(b[:])
-			log.Printf("read %d bytes: % x [err: %v]", n, b[:n], err)
-			if err != nil {
-				break
-			}
+// openPad opens the configured input, with reads timing out when idle
+func openPad(mapping config.Controller, timeout time.Duration) (gamepad.InputSource, error) {
+	switch mapping.Input {
+	case "usb":
+		p, err := gamepad.OpenXbox360(timeout)
+		if err != nil {
+			return nil, err
 		}
-	*/
-
-	x.controller.ReadTimeout = 60 * time.Second
-	for {
-		x.decode()
-		x.send()
-		time.Sleep(time.Millisecond * 6)
+		return p, nil
+	case "evdev":
+		d, err := gamepad.FindDevice(mapping.Device)
+		if err != nil {
+			return nil, err
+		}
+		log.Println("xbox: using", d)
+		p, err := gamepad.OpenEvdev(d.Path, timeout)
+		if err != nil {
+			return nil, err
+		}
+		return p, nil
 	}
+	return nil, fmt.Errorf("unknown controller input %q", mapping.Input)
 }
 
-func (x *xboxCtrl) led(b byte) {
-	x.out.Write([]byte{0x01, 0x03, b})
+// gamepads lists the pads the evdev input can use
+func gamepads(c *cli.Context) {
+	ds, err := gamepad.Devices()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(ds) == 0 {
+		log.Fatal("no joystick devices found")
+	}
+	for _, d := range ds {
+		fmt.Println(d)
+	}
 }
 
-func (x *xboxCtrl) setPlayer(player byte) {
-	spin := []byte{
-		Player1, Player2, Player4, Player3,
-	}
-	spinIdx := 0
-	spinDelay := 100 * time.Millisecond
-
-	x.led(Booting)
-	time.Sleep(100 * time.Millisecond)
-	for spinDelay > 20*time.Millisecond {
-		x.led(spin[spinIdx])
-		time.Sleep(spinDelay)
-		spinIdx = (spinIdx + 1) % len(spin)
-		spinDelay -= 5 * time.Millisecond
-	}
-	for i := 0; i < 40; i++ { // just for safety
-		cur := spin[spinIdx]
-		x.led(cur)
-		time.Sleep(spinDelay)
-		spinIdx = (spinIdx + 1) % len(spin)
-		if cur == player {
-			break
+// padMapping checks the controller configuration and returns its mapping
+func padMapping(c config.Controller) (*gamepad.Mapping, error) {
+	mode, err := gamepad.ParseMode(c.Mode)
+	if err != nil {
+		return nil, err
+	}
+	if c.Rate <= 0 || c.Rate > maxRate {
+		return nil, fmt.Errorf("controller rate must be from 1 to %d Hz", maxRate)
+	}
+	if c.Deadzone < 0 || c.Deadzone >= 1 || c.AxisDeadzone < 0 || c.AxisDeadzone >= 1 {
+		return nil, fmt.Errorf("controller deadzones must be from 0 to below 1")
+	}
+	if c.Expo < 0 || c.Expo > 1 {
+		return nil, fmt.Errorf("controller.expo must be from 0 to 1")
+	}
+
+	m := &gamepad.Mapping{Radial: c.Deadzone, Mode: mode, Limit: c.Limit}
+	for i, a := range []struct {
+		name  string
+		scale float64
+	}{
+		{c.X, c.ScaleX},
+		{c.Y, c.ScaleY},
+		{c.Z, c.ScaleZ},
+	} {
+		if m.Axes[i], err = gamepad.ParseAxis(a.name); err != nil {
+			return nil, err
+		}
+		m.Curves[i] = gamepad.Curve{
+			Deadzone: c.AxisDeadzone,
+			Expo:     c.Expo,
+			Invert:   a.scale < 0,
+			Scale:    c.Scale * math.Abs(a.scale),
 		}
 	}
+	return m, nil
 }
 
-func (x *xboxCtrl) dword(hi, lo byte) int16 {
-	return int16(hi)<<8 | int16(lo)
+func (x *xboxCtrl) send(s gamepad.State) {
+	p := x.mapping.Map(s)
+	x.stream.Set(p[0], p[1], p[2])
 }
 
-func (x *xboxCtrl) decode() {
-	n, err := x.in.Read(x.cur[:])
-	if err != nil || n != 20 {
-		log.Printf("ignoring read: %d bytes, err = %v", n, err)
-		return
+// run drives the arm from pad until ctx is done or the pad is lost, then
+// closes the pad. Reading, mapping and sending run on their own goroutines
+// passing on only the latest state, so a slow read does not hold up the arm
+// nor a slow network the pad. Button actions wait on the arm, so they are
+// queued to another goroutine. A panic on any of them stops the arm.
+func (x *xboxCtrl) run(ctx context.Context, pad gamepad.InputSource) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	x.pad = pad
+	x.fb = newFeedback(pad)
+	x.setSpeed(len(speeds) - 1)
+
+	updates := make(chan padUpdate, 1)
+	actions := make(chan func(), 8)
+
+	var wg sync.WaitGroup
+	for _, f := range []func(){
+		func() { x.stream.Run(ctx) },
+		func() { x.mapLoop(ctx, updates, actions) },
+		func() { x.actionLoop(ctx, actions) },
+		func() { x.fb.run(ctx) },
+		func() { x.fb.watchErrors(ctx) },
+	} {
+		wg.Add(1)
+		go func(f func()) {
+			defer wg.Done()
+			defer halt.Recover()
+			f()
+		}(f)
 	}
 
-	// 1-bit values
-	for _, v := range []struct {
-		idx  int
-		bit  uint
-		name string
-	}{
-		{2, 0, "DPAD U"},
-		{2, 1, "DPAD D"},
-		{2, 2, "DPAD L"},
-		{2, 3, "DPAD R"},
-		{2, 4, "START"},
-		{2, 5, "BACK"},
-		{2, 6, "THUMB L"},
-		{2, 7, "THUMB R"},
-		{3, 0, "LB"},
-		{3, 1, "RB"},
-		{3, 2, "GUIDE"},
-		{3, 4, "A"},
-		{3, 5, "B"},
-		{3, 6, "X"},
-		{3, 7, "Y"},
-	} {
-		c := x.cur[v.idx] & (1 << v.bit)
-		l := x.last[v.idx] & (1 << v.bit)
-		if c == l {
-			continue
+	errc := make(chan error, 1)
+	go func() {
+		defer halt.Recover()
+		errc <- x.readLoop(ctx, updates)
+		cancel()
+	}()
+
+	<-ctx.Done()
+	wg.Wait()
+	x.pad.Close() // ends a blocked Read, or the read times out
+	return <-errc
+}
+
+// readLoop publishes pad states until ctx is done or the pad is lost. It
+// also beats the heart, as reads time out when the pad is idle.
+func (x *xboxCtrl) readLoop(ctx context.Context, updates chan padUpdate) error {
+	for {
+		s, err := x.pad.Read()
+		if ctx.Err() != nil {
+			return nil // the pad may have been closed
 		}
-		switch {
-		case c != 0:
-			log.Printf("Button %q pressed", v.name)
-		case l != 0:
-			log.Printf("Button %q released", v.name)
+		if errors.Is(err, gamepad.ErrDisconnected) {
+			halt.Trigger("xbox controller disconnected")
+			return err
+		}
+		if err != nil {
+			log.Println("xbox: ", err)
 		}
+		heart.Beat()
+		pressed, _ := s.Changed(x.last)
+		x.logChanges(s)
+		publish(updates, padUpdate{s, pressed})
 	}
+}
 
-	// 8-bit values
-	for _, v := range []struct {
-		idx  int
-		name string
+// publish replaces any update not yet taken from c, keeping its presses. It
+// must be the only sender on c.
+func publish(c chan padUpdate, u padUpdate) {
+	select {
+	case old := <-c:
+		u.pressed |= old.pressed
+	default:
+	}
+	c <- u
+}
+
+// mapLoop turns pad updates into setpoints and runs the actions of pressed
+// buttons
+func (x *xboxCtrl) mapLoop(ctx context.Context, updates <-chan padUpdate, actions chan<- func()) {
+	stats := time.NewTicker(10 * time.Second)
+	defer stats.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-stats.C:
+			log.Println("xbox: stream:", x.stream.Stats())
+		case u := <-updates:
+			for _, a := range x.actions {
+				if u.pressed&a.button == 0 {
+					continue
+				}
+				if a.now {
+					a.do()
+					continue
+				}
+				select {
+				case actions <- a.do:
+				default:
+					log.Println("xbox: busy, ignoring", a.button)
+				}
+			}
+			x.send(u.state)
+		}
+	}
+}
+
+// actionLoop runs queued button actions in order
+func (x *xboxCtrl) actionLoop(ctx context.Context, actions <-chan func()) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case do := <-actions:
+			do()
+		}
+	}
+}
+
+// bind binds the configured buttons to actions. The e-stop runs at once,
+// before anything else pressed at the same time.
+func (x *xboxCtrl) bind(b config.Buttons) error {
+	for _, a := range []struct {
+		key, button string
+		do          func()
 	}{
-		{4, "LT"},
-		{5, "RT"},
+		{"e-stop", b.EStop, func() { halt.Trigger(fmt.Sprintf("gamepad %s pressed", b.EStop)) }},
+		{"start", b.Start, x.start},
+		{"stop", b.Stop, x.stop},
+		{"home", b.Home, x.home},
+		{"save", b.Save, x.save},
+		{"recall", b.Recall, x.recallNext},
+		{"slower", b.Slower, func() { x.setSpeed(x.speed - 1) }},
+		{"faster", b.Faster, func() { x.setSpeed(x.speed + 1) }},
 	} {
-		c := x.cur[v.idx]
-		l := x.last[v.idx]
-		if c == l {
+		if a.button == "" {
 			continue
 		}
-		log.Printf("Trigger %q = %v", v.name, c)
+		btn, err := gamepad.ParseButton(a.button)
+		if err != nil {
+			return fmt.Errorf("buttons.%s: %v", a.key, err)
+		}
+		x.actions = append(x.actions, padAction{btn, a.do, a.key == "e-stop"})
+	}
+	return nil
+}
+
+func (x *xboxCtrl) start() {
+	if halt.Triggered() {
+		log.Println("xbox: e-stop latched, restart the command to move again")
+		return
 	}
+	ctx, cancel := context.WithTimeout(cmdCtx, cfg.Timeout)
+	defer cancel()
+	if err := arm.Start(ctx); err != nil {
+		log.Println("xbox: start: ", err)
+		return
+	}
+	x.fb.setStopped(false)
+	log.Println("xbox: started")
+}
 
-	/*
-		//     +y
-		//      N
-		// -x W-|-E +x
-		//      S
-		//     -y
-		dirs := [...]string{
-			"W", "SW", "S", "SE", "E", "NE", "N", "NW", "W",
-		}
-		dir := func(x, y int16) (string, int32) {
-			// Direction
-			rad := math.Atan2(float64(y), float64(x))
-			dir := 4 * rad / math.Pi
-			card := int(dir + math.Copysign(0.5, dir))
-
-			// Magnitude
-			mag := math.Sqrt(float64(x)*float64(x) + float64(y)*float64(y))
-			return dirs[card+4], int32(mag)
-		} */
-
-	// 16-bit values
-	// LS
-	x.xLS = float64(x.dword(x.cur[7], x.cur[6]))
-	x.yLS = float64(x.dword(x.cur[9], x.cur[8]))
-
-	// RS
-	x.xRS = float64(x.dword(x.cur[11], x.cur[10]))
-	x.yRS = float64(x.dword(x.cur[13], x.cur[12]))
-
-	/*
-		for _, v := range []struct {
-			hiX, loX int
-			hiY, loY int
-			name     string
-		}{
-			{7, 6, 9, 8, "LS"},
-			{11, 10, 13, 12, "RS"},
-		} {
-			c, cmag := dir(
-				x.dword(x.cur[v.hiX], x.cur[v.loX]),
-				x.dword(x.cur[v.hiY], x.cur[v.loY]),
-			)
-			l, lmag := dir(
-				x.dword(x.last[v.hiX], x.last[v.loX]),
-				x.dword(x.last[v.hiY], x.last[v.loY]),
-			)
-			ccenter := cmag < 10240
-			lcenter := lmag < 10240
-			if ccenter && lcenter {
-				continue
-			}
-			if c == l && cmag == lmag {
-				continue
-			}
-			if cmag > 10240 {
-				log.Printf("Stick %q = %v x %v", v.name, c, cmag)
-			} else {
-				log.Printf("Stick %q centered", v.name)
-			}
+func (x *xboxCtrl) stop() {
+	x.fb.setStopped(true)
+	ctx, cancel := context.WithTimeout(cmdCtx, cfg.Timeout)
+	defer cancel()
+	if err := arm.Stop(ctx); err != nil {
+		log.Println("xbox: stop: ", err)
+		return
+	}
+	log.Println("xbox: stopped")
+}
+
+func (x *xboxCtrl) home() {
+	log.Println("xbox: home")
+	x.moveTo([3]float64{})
+}
+
+// save saves the current position as a waypoint
+func (x *xboxCtrl) save() {
+	p := x.mapping.Position()
+	x.waypoints = append(x.waypoints, p)
+	log.Printf("xbox: waypoint %d saved at (%f, %f, %f)", len(x.waypoints), p[0], p[1], p[2])
+}
+
+// recallNext moves to the saved waypoints in turn
+func (x *xboxCtrl) recallNext() {
+	if len(x.waypoints) == 0 {
+		log.Println("xbox: no waypoints saved")
+		return
+	}
+	p := x.waypoints[x.recall]
+	log.Printf("xbox: waypoint %d recalled at (%f, %f, %f)", x.recall+1, p[0], p[1], p[2])
+	x.moveTo(p)
+	x.recall = (x.recall + 1) % len(x.waypoints)
+}
+
+// moveTo moves the origin to p within the motion limits rather than in one
+// step, which would slam the arm across
+func (x *xboxCtrl) moveTo(p [3]float64) {
+	o := x.mapping.Origin()
+	t, err := trajectory.New([]trajectory.Point{{X: o[0], Y: o[1], Z: o[2]}, {X: p[0], Y: p[1], Z: p[2]}}, x.limits)
+	if err != nil {
+		log.Println("xbox: ", err)
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.move, x.moveStart = t, time.Now()
+}
+
+// followMove moves the origin along the move in progress, if any
+func (x *xboxCtrl) followMove() {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.move == nil {
+		return
+	}
+	d := time.Since(x.moveStart)
+	p := x.move.At(d)
+	if d >= x.move.Duration() {
+		p = x.move.End()
+		x.move = nil
+	}
+	x.mapping.MoveTo([3]float64{p.X, p.Y, p.Z})
+}
+
+// setSpeed selects speeds[i], shown on the LED ring
+func (x *xboxCtrl) setSpeed(i int) {
+	if i < 0 || i >= len(speeds) {
+		return
+	}
+	x.speed = i
+	x.mapping.SetSpeed(speeds[i])
+	x.fb.setSpeed(i)
+	log.Printf("xbox: speed %v", speeds[i])
+}
+
+// logChanges logs button edges and trigger movement
+func (x *xboxCtrl) logChanges(s gamepad.State) {
+	pressed, released := s.Changed(x.last)
+	for _, b := range gamepad.Buttons {
+		switch {
+		case pressed&b != 0:
+			log.Printf("Button %q pressed", b)
+		case released&b != 0:
+			log.Printf("Button %q released", b)
 		}
-	*/
-	x.last, x.cur = x.cur, x.last
+	}
+	if s.LT != x.last.LT {
+		log.Printf("Trigger %q = %.2f", "LT", s.LT)
+	}
+	if s.RT != x.last.RT {
+		log.Printf("Trigger %q = %.2f", "RT", s.RT)
+	}
+	x.last = s
 }
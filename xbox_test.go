@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/afking/godelta/client"
+	"github.com/afking/godelta/config"
+	"github.com/afking/godelta/gamepad"
+	"github.com/afking/godelta/safety"
+	"github.com/afking/godelta/sim"
+	"github.com/afking/godelta/trajectory"
+	"github.com/afking/godelta/workspace"
+)
+
+var testLimits = trajectory.Limits{Velocity: 0.04, Acceleration: 0.2, Jerk: 2}
+
+// simArm connects arm to a simulated arm and sets up the rest of what a safe
+// command runs with, returning the simulator.
+func simArm(t *testing.T) *sim.Server {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := sim.New()
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	arm = client.New(conn)
+	arm.Ack = true
+	t.Cleanup(func() { arm.Close() })
+
+	cfg = config.Default()
+	ws = &workspace.Workspace{}
+	halt = safety.NewEStop(arm.EStop)
+	heart = safety.NewHeart(0, 0, nil)
+	return s
+}
+
+// runXbox runs the pad controller with c until ctx is done, returning the
+// pad and run's result. It is stopped by the end of the test.
+func runXbox(t *testing.T, ctx context.Context, c config.Controller) (*gamepad.Chan, <-chan error) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(ctx)
+	cmdCtx = ctx
+	x, err := newXbox(c, testLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pad := gamepad.NewChan()
+	errc := make(chan error, 1)
+	done := make(chan struct{})
+	go func() {
+		errc <- x.run(ctx, pad)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return pad, errc
+}
+
+// press presses and releases b, holding the sticks as in s.
+func press(pad *gamepad.Chan, b gamepad.Button, s gamepad.State) {
+	s.Buttons = b
+	pad.C <- s
+	s.Buttons = 0
+	pad.C <- s
+}
+
+// waitFor polls cond until it holds or a few seconds have passed.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for end := time.Now().Add(5 * time.Second); !cond(); time.Sleep(time.Millisecond) {
+		if time.Now().After(end) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+// result waits for run to return.
+func result(t *testing.T, errc <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-errc:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("run did not return")
+		return nil
+	}
+}
+
+func TestXboxCancel(t *testing.T) {
+	s := simArm(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pad, errc := runXbox(t, ctx, cfg.Controller)
+
+	press(pad, gamepad.Start, gamepad.State{})
+	waitFor(t, "START", s.Started)
+
+	// Full deflection is controller.scale in absolute mode
+	pad.C <- gamepad.State{LX: 1, RY: -0.5}
+	waitFor(t, "the stick position", func() bool {
+		x, y, z := s.Point()
+		return math.Abs(x-0.04) < 1e-9 && y == 0 && z < -0.01
+	})
+
+	// Cancelling ends run with the pad idle in Read
+	cancel()
+	if err := result(t, errc); err != nil {
+		t.Errorf("run = %v after cancel, want nil", err)
+	}
+	if halt.Triggered() {
+		t.Error("e-stop triggered by cancel")
+	}
+	if !s.Started() {
+		t.Error("arm stopped by cancel")
+	}
+}
+
+func TestXboxPadLost(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		lose func(*gamepad.Chan)
+	}{
+		{"closed", func(pad *gamepad.Chan) { pad.Close() }},
+		{"unplugged", func(pad *gamepad.Chan) { close(pad.C) }},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := simArm(t)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			pad, errc := runXbox(t, ctx, cfg.Controller)
+
+			press(pad, gamepad.Start, gamepad.State{})
+			waitFor(t, "START", s.Started)
+
+			tt.lose(pad)
+			if err := result(t, errc); !errors.Is(err, gamepad.ErrDisconnected) {
+				t.Errorf("run = %v, want ErrDisconnected", err)
+			}
+			if !halt.Triggered() {
+				t.Error("e-stop not triggered")
+			}
+			waitFor(t, "STOP", func() bool { return !s.Started() })
+		})
+	}
+}
+
+func TestXboxEStop(t *testing.T) {
+	s := simArm(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pad, errc := runXbox(t, ctx, cfg.Controller)
+
+	press(pad, gamepad.Start, gamepad.State{})
+	waitFor(t, "START", s.Started)
+	press(pad, gamepad.B, gamepad.State{})
+	waitFor(t, "STOP", func() bool { return !s.Started() })
+	if !halt.Triggered() {
+		t.Error("e-stop not triggered by B")
+	}
+
+	// Latched until the command is restarted
+	press(pad, gamepad.Start, gamepad.State{})
+	time.Sleep(50 * time.Millisecond)
+	if s.Started() {
+		t.Error("START after the e-stop restarted the arm")
+	}
+
+	cancel()
+	if err := result(t, errc); err != nil {
+		t.Errorf("run = %v, want nil", err)
+	}
+}
+
+func TestXboxHome(t *testing.T) {
+	s := simArm(t)
+	c := cfg.Controller
+	c.Mode = "velocity"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pad, errc := runXbox(t, ctx, c)
+
+	press(pad, gamepad.Start, gamepad.State{})
+	waitFor(t, "START", s.Started)
+
+	// Jog away at controller.scale m/s
+	pad.C <- gamepad.State{LX: 1}
+	waitFor(t, "jogging", func() bool {
+		x, _, _ := s.Point()
+		return x > 0.01
+	})
+	pad.C <- gamepad.State{}
+	time.Sleep(20 * time.Millisecond)
+
+	// Home ramps back within the limits rather than jumping
+	press(pad, gamepad.A, gamepad.State{})
+	prev, _, _ := s.Point()
+	maxStep := 0.0
+	waitFor(t, "home", func() bool {
+		x, _, _ := s.Point()
+		maxStep = math.Max(maxStep, math.Abs(x-prev))
+		prev = x
+		return x == 0
+	})
+	if maxStep > 0.002 {
+		t.Errorf("home moved %g m between samples, want a ramp", maxStep)
+	}
+
+	cancel()
+	if err := result(t, errc); err != nil {
+		t.Errorf("run = %v, want nil", err)
+	}
+}